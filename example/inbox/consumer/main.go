@@ -14,6 +14,7 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 
 	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/amqpsource"
 )
 
 func main() {
@@ -60,9 +61,24 @@ func main() {
 		log.Fatal(err)
 	}
 
+	source := amqpsource.New(
+		*useAmqpAddress,
+		inboxStorage.Writer(),
+		[]string{"multy-handlers", "single-handler"},
+		amqpsource.WithIDExtractor(idFromBody),
+		amqpsource.WithEventTypeExtractor(eventTypeFromRoutingKey),
+		amqpsource.OnErrorCallback(func(err error) {
+			log.Printf("%s", err)
+		}),
+	)
+
+	if err = source.Start(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
 	log.Println("started")
 
-	consumer.Consume(inboxStorage.Writer())
+	select {}
 }
 
 type RandomConsumer struct {
@@ -101,44 +117,30 @@ type rawBody struct {
 	ID uuid.UUID `json:"id"`
 }
 
-func (c *RandomConsumer) Consume(writer inbox.Client) {
-	ch1, _ := c.cc.Consume("multy-handlers", "", false, false, false, false, nil)
-	ch2, _ := c.cc.Consume("single-handler", "", false, false, false, false, nil)
-
-	for {
-		select {
-		case body := <-ch1:
-			event := c.parse(body.Body)
-
-			record, _ := inbox.NewRecord(event.ID, "multy-handlers", body.Body)
-
-			log.Printf("write event from multy-handlers")
-
-			_ = writer.WriteInbox(context.Background(), record)
-
-			_ = body.Ack(false)
-		case body := <-ch2:
-			event := c.parse(body.Body)
-
-			record, _ := inbox.NewRecord(event.ID, "single-handler", body.Body)
-
-			log.Printf("write event from single-handler")
-
-			_ = writer.WriteInbox(context.Background(), record)
+// idFromBody takes the event ID from the payload, because the
+// publisher does not set the message-id property.
+func idFromBody(d amqp.Delivery) (uuid.UUID, error) {
+	var event rawBody
 
-			_ = body.Ack(false)
-		}
+	if err := json.Unmarshal(d.Body, &event); err != nil {
+		return uuid.Nil, err
 	}
+
+	return event.ID, nil
 }
 
-func (c *RandomConsumer) parse(body []byte) rawBody {
-	var event rawBody
+var eventTypes = map[string]string{
+	"multy-handlers-subject": "multy-handlers",
+	"single-handler-subject": "single-handler",
+}
 
-	if err := json.Unmarshal(body, &event); err != nil {
-		log.Printf("%s", err)
+func eventTypeFromRoutingKey(d amqp.Delivery) (string, error) {
+	eventType, ok := eventTypes[d.RoutingKey]
+	if !ok {
+		return "", fmt.Errorf("unknown routing key %q", d.RoutingKey)
 	}
 
-	return event
+	return eventType, nil
 }
 
 type firstMultiHandler struct{}
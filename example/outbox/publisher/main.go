@@ -11,9 +11,9 @@ import (
 
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
-	amqp "github.com/rabbitmq/amqp091-go"
 
 	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/amqpbroker"
 )
 
 func main() {
@@ -40,11 +40,13 @@ func main() {
 		log.Fatal(err)
 	}
 
-	broker, err := NewRandomPublisher(*useAmqpAddress)
+	broker, err := amqpbroker.New(*useAmqpAddress)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	defer broker.Close()
+
 	ob := outbox.NewOutbox(broker, db)
 
 	if err = ob.Start(context.Background()); err != nil {
@@ -56,39 +58,6 @@ func main() {
 	cycle.Start()
 }
 
-type RandomPublisher struct {
-	cc *amqp.Channel
-}
-
-func NewRandomPublisher(amqpAddress string) (*RandomPublisher, error) {
-	conn, err := amqp.Dial(amqpAddress)
-	if err != nil {
-		return nil, err
-	}
-
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, err
-	}
-
-	return &RandomPublisher{
-		cc: ch,
-	}, nil
-}
-
-func (p *RandomPublisher) Publish(ctx context.Context, subject string, body []byte) error {
-	return p.cc.Publish(
-		"",
-		subject,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
-	)
-}
-
 type body struct {
 	A int     `json:"a"`
 	B float64 `json:"b"`
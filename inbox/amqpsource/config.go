@@ -0,0 +1,209 @@
+package amqpsource
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/Melenium2/go-iobox/backoff"
+)
+
+const (
+	// DefaultPrefetch is the max number of deliveries that are not
+	// acknowledged yet per queue.
+	DefaultPrefetch = 10
+	// DefaultWriteTimeout is the timeout after which writing of the
+	// delivery to the inbox table is canceled.
+	DefaultWriteTimeout = 5 * time.Second
+	// DefaultRetryMin is the min delay before the failed delivery
+	// is requeued.
+	DefaultRetryMin = time.Second
+	// DefaultRetryMax is the max delay before the failed delivery
+	// is requeued.
+	DefaultRetryMax = 30 * time.Second
+	// DefaultReconnectDelay is the delay between attempts to
+	// reconnect to RabbitMQ.
+	DefaultReconnectDelay = 5 * time.Second
+	// DefaultLocale is the locale used to dial RabbitMQ.
+	DefaultLocale = "en_US"
+)
+
+type (
+	// IDExtractor returns the event ID of the delivery.
+	IDExtractor func(amqp.Delivery) (uuid.UUID, error)
+	// EventTypeExtractor returns the event type of the delivery.
+	EventTypeExtractor func(amqp.Delivery) (string, error)
+	// ErrorCallback prototype of function that is called if errors occurs
+	// during consuming.
+	ErrorCallback func(err error)
+)
+
+func nopErrorCallback(error) {}
+
+// IDFromMessageID parses the event ID from the message-id property.
+func IDFromMessageID(d amqp.Delivery) (uuid.UUID, error) {
+	id, err := uuid.Parse(d.MessageId)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("incorrect message id %q, %w", d.MessageId, err)
+	}
+
+	return id, nil
+}
+
+// IDFromHeader parses the event ID from the header.
+func IDFromHeader(header string) IDExtractor {
+	return func(d amqp.Delivery) (uuid.UUID, error) {
+		value, ok := d.Headers[header].(string)
+		if !ok {
+			return uuid.Nil, fmt.Errorf("header %q not found", header)
+		}
+
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("incorrect event id in header %q, %w", header, err)
+		}
+
+		return id, nil
+	}
+}
+
+// EventTypeFromRoutingKey uses the routing key as the event type.
+func EventTypeFromRoutingKey(d amqp.Delivery) (string, error) {
+	return d.RoutingKey, nil
+}
+
+// EventTypeFromHeader takes the event type from the header.
+func EventTypeFromHeader(header string) EventTypeExtractor {
+	return func(d amqp.Delivery) (string, error) {
+		value, ok := d.Headers[header].(string)
+		if !ok {
+			return "", fmt.Errorf("header %q not found", header)
+		}
+
+		return value, nil
+	}
+}
+
+type config struct {
+	prefetch       int
+	writeTimeout   time.Duration
+	retry          backoff.Config
+	reconnectDelay time.Duration
+	amqpConfig     amqp.Config
+	id             IDExtractor
+	eventType      EventTypeExtractor
+	onError        ErrorCallback
+}
+
+func defaultConfig() config {
+	return config{
+		prefetch:     DefaultPrefetch,
+		writeTimeout: DefaultWriteTimeout,
+		retry: backoff.Config{
+			Min: DefaultRetryMin,
+			Max: DefaultRetryMax,
+		},
+		reconnectDelay: DefaultReconnectDelay,
+		amqpConfig:     amqp.Config{Locale: DefaultLocale},
+		id:             IDFromMessageID,
+		eventType:      EventTypeFromRoutingKey,
+		onError:        nopErrorCallback,
+	}
+}
+
+// Option sets specific configuration to the Source.
+type Option func(config) config
+
+// WithPrefetch sets the max number of deliveries that are not
+// acknowledged yet per queue. Failed deliveries are held during the
+// retry delay and count against the prefetch, so consuming is paused
+// if all prefetched deliveries are failed, e.g. the database is not
+// available. Value less or equal to zero is ignored.
+func WithPrefetch(prefetch int) Option {
+	return func(c config) config {
+		if prefetch > 0 {
+			c.prefetch = prefetch
+		}
+
+		return c
+	}
+}
+
+// WithWriteTimeout sets the timeout of writing the delivery to
+// the inbox table.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c config) config {
+		c.writeTimeout = timeout
+
+		return c
+	}
+}
+
+// WithRetryBackoff sets the min and max delay before the failed
+// delivery is requeued. The delay grows with the number of
+// consecutive failures.
+func WithRetryBackoff(minDelay, maxDelay time.Duration) Option {
+	return func(c config) config {
+		c.retry = backoff.Config{
+			Min: minDelay,
+			Max: maxDelay,
+		}
+
+		return c
+	}
+}
+
+// WithReconnectDelay sets the delay between attempts to reconnect
+// to RabbitMQ.
+func WithReconnectDelay(delay time.Duration) Option {
+	return func(c config) config {
+		c.reconnectDelay = delay
+
+		return c
+	}
+}
+
+// WithAMQPConfig sets the config used to dial RabbitMQ.
+func WithAMQPConfig(cfg amqp.Config) Option {
+	return func(c config) config {
+		c.amqpConfig = cfg
+
+		return c
+	}
+}
+
+// WithIDExtractor sets the function that returns the event ID of the
+// delivery. By default, the ID is parsed from the message-id property.
+func WithIDExtractor(extractor IDExtractor) Option {
+	return func(c config) config {
+		if extractor != nil {
+			c.id = extractor
+		}
+
+		return c
+	}
+}
+
+// WithEventTypeExtractor sets the function that returns the event type
+// of the delivery. By default, the routing key is used as event type.
+func WithEventTypeExtractor(extractor EventTypeExtractor) Option {
+	return func(c config) config {
+		if extractor != nil {
+			c.eventType = extractor
+		}
+
+		return c
+	}
+}
+
+// OnErrorCallback sets the callback which is called if error
+// occurs during consuming.
+func OnErrorCallback(callback ErrorCallback) Option {
+	return func(c config) config {
+		c.onError = callback
+
+		return c
+	}
+}
@@ -0,0 +1,11 @@
+package amqpsource
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func (s *Source) Handle(ctx context.Context, d amqp.Delivery) {
+	s.handle(ctx, d)
+}
@@ -0,0 +1,278 @@
+// Package amqpsource consumes events from RabbitMQ and writes them
+// to the inbox table.
+//
+// The delivery is acknowledged only after it is written to the inbox
+// table. If writing fails, the delivery is requeued after the retry
+// delay, so the event is never lost.
+package amqpsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/Melenium2/go-iobox/backoff"
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/internal/lifecycle"
+)
+
+// Source consumes events from RabbitMQ queues and writes them to
+// the inbox table. Connection is recovered automatically if it is
+// closed.
+type Source struct {
+	url    string
+	queues []string
+	writer inbox.Client
+	config config
+
+	backoff *backoff.Backoff
+	// failures is the number of consecutive failed deliveries.
+	failures atomic.Int64
+
+	lifecycle *lifecycle.Lifecycle
+}
+
+// New creates new Source consuming the queues. Use inbox.Inbox.Writer
+// as the writer.
+func New(url string, writer inbox.Client, queues []string, opts ...Option) *Source {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return &Source{
+		url:       url,
+		queues:    queues,
+		writer:    writer,
+		config:    cfg,
+		backoff:   backoff.NewBackoff(cfg.retry),
+		lifecycle: lifecycle.New(),
+	}
+}
+
+// Start connects to RabbitMQ and starts consuming. Closing the context
+// stops consuming without waiting for in-flight deliveries, use Shutdown
+// to stop the source gracefully.
+func (s *Source) Start(ctx context.Context) error {
+	sess, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	// In-flight deliveries are written with context that is not
+	// canceled with ctx, so they can be finished during shutdown.
+	inFlight := s.lifecycle.InFlight(ctx)
+
+	s.lifecycle.Go(func() {
+		s.run(ctx, inFlight, sess)
+	})
+
+	return nil
+}
+
+// Shutdown stops consuming and waits for in-flight deliveries until
+// the context is done. Deliveries that are not acknowledged are
+// requeued by RabbitMQ after the connection is closed.
+func (s *Source) Shutdown(ctx context.Context) error {
+	return s.lifecycle.Shutdown(ctx)
+}
+
+type session struct {
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	deliveries []<-chan amqp.Delivery
+}
+
+func (s *session) close() {
+	_ = s.channel.Close()
+	_ = s.conn.Close()
+}
+
+func (s *Source) connect() (*session, error) {
+	conn, err := amqp.DialConfig(s.url, s.config.amqpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("can not connect to broker, %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("can not open channel, %w", err)
+	}
+
+	sess := &session{
+		conn:       conn,
+		channel:    ch,
+		deliveries: make([]<-chan amqp.Delivery, 0, len(s.queues)),
+	}
+
+	if err := ch.Qos(s.config.prefetch, 0, false); err != nil {
+		sess.close()
+
+		return nil, fmt.Errorf("can not set prefetch, %w", err)
+	}
+
+	for _, queue := range s.queues {
+		deliveries, err := ch.Consume(queue, "", false, false, false, false, nil)
+		if err != nil {
+			sess.close()
+
+			return nil, fmt.Errorf("can not consume queue %q, %w", queue, err)
+		}
+
+		sess.deliveries = append(sess.deliveries, deliveries)
+	}
+
+	return sess, nil
+}
+
+// run consumes deliveries of the session and reconnects if the
+// session is closed.
+func (s *Source) run(ctx, inFlight context.Context, sess *session) {
+	for {
+		s.consume(ctx, inFlight, sess)
+
+		sess.close()
+
+		if ctx.Err() != nil || s.lifecycle.IsStopped() {
+			return
+		}
+
+		s.config.onError(errors.New("broker connection closed, reconnecting"))
+
+		sess = s.reconnect(ctx)
+		if sess == nil {
+			return
+		}
+	}
+}
+
+// reconnect tries to connect until it succeeds or the source is stopped.
+func (s *Source) reconnect(ctx context.Context) *session {
+	timer := time.NewTimer(s.config.reconnectDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil
+		case <-s.lifecycle.Stopped():
+			return nil
+		}
+
+		sess, err := s.connect()
+		if err == nil {
+			return sess
+		}
+
+		s.config.onError(err)
+
+		timer.Reset(s.config.reconnectDelay)
+	}
+}
+
+// consume handles deliveries of all queues until the session is closed
+// or the source is stopped.
+func (s *Source) consume(ctx, inFlight context.Context, sess *session) {
+	var wg sync.WaitGroup
+
+	for _, deliveries := range sess.deliveries {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case d, ok := <-deliveries:
+					if !ok {
+						return
+					}
+
+					s.handle(inFlight, d)
+				case <-ctx.Done():
+					return
+				case <-s.lifecycle.Stopped():
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// handle writes the delivery to the inbox table and acknowledges it.
+// Delivery that can not be mapped to the inbox record is rejected,
+// because it never succeeds.
+func (s *Source) handle(ctx context.Context, d amqp.Delivery) {
+	record, err := s.record(d)
+	if err != nil {
+		s.config.onError(err)
+
+		if err := d.Reject(false); err != nil {
+			s.config.onError(fmt.Errorf("delivery not rejected, %w", err))
+		}
+
+		return
+	}
+
+	if err := s.write(ctx, record); err != nil {
+		s.config.onError(err)
+
+		s.retry(d)
+
+		return
+	}
+
+	s.failures.Store(0)
+
+	if err := d.Ack(false); err != nil {
+		s.config.onError(fmt.Errorf("delivery not acked, %w", err))
+	}
+}
+
+func (s *Source) record(d amqp.Delivery) (*inbox.Record, error) {
+	id, err := s.config.id(d)
+	if err != nil {
+		return nil, fmt.Errorf("event id not extracted, %w", err)
+	}
+
+	eventType, err := s.config.eventType(d)
+	if err != nil {
+		return nil, fmt.Errorf("event type not extracted, %w", err)
+	}
+
+	return inbox.NewRecord(id, eventType, d.Body, d.Timestamp)
+}
+
+func (s *Source) write(ctx context.Context, record *inbox.Record) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.writeTimeout)
+	defer cancel()
+
+	if err := s.writer.WriteInbox(ctx, record); err != nil {
+		return fmt.Errorf("event not written to inbox, %w", err)
+	}
+
+	return nil
+}
+
+// retry requeues the delivery after the backoff delay. The delivery is
+// held during the delay and counts against the prefetch, so RabbitMQ
+// does not redeliver it immediately.
+func (s *Source) retry(d amqp.Delivery) {
+	attempt := s.failures.Add(1)
+
+	time.AfterFunc(s.backoff.Next(int(attempt)), func() {
+		// The delivery is already requeued if the channel is closed.
+		_ = d.Nack(false, true)
+	})
+}
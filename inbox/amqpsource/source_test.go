@@ -0,0 +1,180 @@
+package amqpsource_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/amqpsource"
+)
+
+type fakeWriter struct {
+	mu      sync.Mutex
+	err     error
+	records []*inbox.Record
+}
+
+func (w *fakeWriter) WriteInbox(_ context.Context, record *inbox.Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	w.records = append(w.records, record)
+
+	return nil
+}
+
+// fakeAcknowledger saves the acknowledgement of the delivery.
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	acked   bool
+	nacked  bool
+	requeue bool
+	done    chan struct{}
+}
+
+func newFakeAcknowledger() *fakeAcknowledger {
+	return &fakeAcknowledger{done: make(chan struct{})}
+}
+
+func (a *fakeAcknowledger) Ack(uint64, bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.acked = true
+	close(a.done)
+
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.nacked = true
+	a.requeue = requeue
+	close(a.done)
+
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(_ uint64, requeue bool) error {
+	return a.Nack(0, false, requeue)
+}
+
+func (a *fakeAcknowledger) wait(t *testing.T) {
+	select {
+	case <-a.done:
+	case <-time.After(time.Second):
+		t.Fatal("delivery is not acknowledged")
+	}
+}
+
+var eventDate = time.Date(2024, 6, 5, 17, 55, 2, 0, time.UTC)
+
+func delivery(ack amqp.Acknowledger, id string) amqp.Delivery {
+	return amqp.Delivery{
+		Acknowledger: ack,
+		MessageId:    id,
+		RoutingKey:   "order.created",
+		Headers:      amqp.Table{"event-type": "order.paid", "event-id": id},
+		Timestamp:    eventDate,
+		Body:         []byte("{}"),
+	}
+}
+
+func TestHandle_Should_ack_delivery_after_writing_to_inbox(t *testing.T) {
+	var (
+		writer = &fakeWriter{}
+		ack    = newFakeAcknowledger()
+		id     = uuid.New()
+		source = amqpsource.New("", writer, nil)
+	)
+
+	source.Handle(context.Background(), delivery(ack, id.String()))
+
+	ack.wait(t)
+	assert.True(t, ack.acked)
+	require.Len(t, writer.records, 1)
+
+	expected, _ := inbox.NewRecord(id, "order.created", []byte("{}"), eventDate)
+	assert.Equal(t, expected, writer.records[0])
+}
+
+func TestHandle_Should_requeue_delivery_if_writing_failed(t *testing.T) {
+	var (
+		writer = &fakeWriter{err: errors.New("db is down")}
+		ack    = newFakeAcknowledger()
+		errs   = make([]error, 0)
+		source = amqpsource.New(
+			"",
+			writer,
+			nil,
+			amqpsource.WithRetryBackoff(time.Millisecond, 10*time.Millisecond),
+			amqpsource.OnErrorCallback(func(err error) {
+				errs = append(errs, err)
+			}),
+		)
+	)
+
+	source.Handle(context.Background(), delivery(ack, uuid.NewString()))
+
+	ack.wait(t)
+	assert.False(t, ack.acked)
+	assert.True(t, ack.nacked)
+	assert.True(t, ack.requeue)
+	assert.Len(t, errs, 1)
+}
+
+func TestHandle_Should_reject_delivery_with_incorrect_id(t *testing.T) {
+	var (
+		writer = &fakeWriter{}
+		ack    = newFakeAcknowledger()
+		source = amqpsource.New("", writer, nil)
+	)
+
+	source.Handle(context.Background(), delivery(ack, "not-uuid"))
+
+	ack.wait(t)
+	assert.True(t, ack.nacked)
+	assert.False(t, ack.requeue)
+	assert.Empty(t, writer.records)
+}
+
+func TestHandle_Should_extract_id_and_event_type_from_headers(t *testing.T) {
+	var (
+		writer = &fakeWriter{}
+		ack    = newFakeAcknowledger()
+		id     = uuid.New()
+		source = amqpsource.New(
+			"",
+			writer,
+			nil,
+			amqpsource.WithIDExtractor(amqpsource.IDFromHeader("event-id")),
+			amqpsource.WithEventTypeExtractor(amqpsource.EventTypeFromHeader("event-type")),
+		)
+	)
+
+	d := delivery(ack, "")
+	d.Headers["event-id"] = id.String()
+
+	source.Handle(context.Background(), d)
+
+	ack.wait(t)
+	assert.True(t, ack.acked)
+	require.Len(t, writer.records, 1)
+
+	expected, _ := inbox.NewRecord(id, "order.paid", []byte("{}"), eventDate)
+	assert.Equal(t, expected, writer.records[0])
+}
@@ -9,11 +9,11 @@ type Client interface {
 }
 
 type client struct {
-	storage  *defaultStorage
+	storage  Storage
 	handlers map[string][]string
 }
 
-func newClient(storage *defaultStorage, handlers map[string][]Handler) *client {
+func newClient(storage Storage, handlers map[string][]Handler) *client {
 	handlerKeys := make(map[string][]string, len(handlers))
 
 	for eventType, handlerList := range handlers {
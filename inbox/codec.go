@@ -0,0 +1,21 @@
+package inbox
+
+import (
+	"encoding/json"
+)
+
+// Codec decodes the payload of the event into the value of the typed
+// handler. The package protocodec provides the Codec of protobuf messages.
+type Codec interface {
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v any) error
+}
+
+var _ Codec = JSONCodec{}
+
+// JSONCodec decodes JSON payloads with encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
@@ -22,8 +22,25 @@ const (
 	// as 'dead'. 'Dead' means that the event will no longer be
 	// processed.
 	DefaultRetryAttempts = 5
+	// DefaultTableName is the name of the inbox table.
+	DefaultTableName = "__inbox_table"
+	// DefaultBatchSize is the max number of records fetched from the inbox
+	// table in one batch.
+	DefaultBatchSize = 100
+	// DefaultLeaseTimeout is the time after which records stuck in 'progress'
+	// status are fetched again. Records get stuck if the worker dies before
+	// their status is updated. The lease must be longer than the time needed
+	// to process the whole batch if records are processed one after another.
+	DefaultLeaseTimeout = 5 * time.Minute
+	// DefaultConcurrency is the number of records processed
+	// at the same time.
+	DefaultConcurrency = 1
 )
 
+// noHandlerDelay is the delay of the next attempt of the record which
+// handler is not registered.
+const noHandlerDelay = time.Minute
+
 type (
 	// DeadCallback prototype of function that is called if message is 'dead'
 	DeadCallback func(eventID uuid.UUID, msg string)
@@ -40,9 +57,18 @@ type config struct {
 	iterationSeed    int
 	handlerTimeout   time.Duration
 	maxRetryAttempts int
-	retention        retention.Config
-	onDead           DeadCallback
-	onError          ErrorCallback
+	batchSize        int
+	leaseTimeout     time.Duration
+	concurrency      int
+	// handlerConcurrency limits the number of records processed
+	// at the same time by the handler with the key.
+	handlerConcurrency map[string]int
+	tableName          string
+	schema             string
+	retention          retention.Config
+	storage            Storage
+	onDead             DeadCallback
+	onError            ErrorCallback
 }
 
 func defaultConfig() config {
@@ -51,6 +77,10 @@ func defaultConfig() config {
 		iterationSeed:    DefaultIterationSeed,
 		handlerTimeout:   DefaultHandlerTimeout,
 		maxRetryAttempts: DefaultRetryAttempts,
+		batchSize:        DefaultBatchSize,
+		leaseTimeout:     DefaultLeaseTimeout,
+		concurrency:      DefaultConcurrency,
+		tableName:        DefaultTableName,
 		retention:        retention.Config{},
 		onDead:           nopDeadCallback,
 		onError:          nopErrorCallback,
@@ -97,6 +127,107 @@ func WithMaxRetryAttempt(maxAttempt int) Option {
 	}
 }
 
+// WithBatchSize sets the max number of records fetched from the inbox
+// table in one batch. Rows locked by other replicas are skipped, so
+// several replicas can split the backlog. Non-positive values are ignored.
+func WithBatchSize(size int) Option {
+	return func(c config) config {
+		if size > 0 {
+			c.batchSize = size
+		}
+
+		return c
+	}
+}
+
+// WithLeaseTimeout sets the time after which records stuck in 'progress'
+// status are fetched and processed again. Each expired lease is counted
+// as a failed attempt, so the record is marked as 'dead' if the worker
+// dies on it too many times. Non-positive values are ignored.
+func WithLeaseTimeout(dur time.Duration) Option {
+	return func(c config) config {
+		if dur > 0 {
+			c.leaseTimeout = dur
+		}
+
+		return c
+	}
+}
+
+// WithConcurrency sets the number of records processed at the same time.
+// Records of different handler keys take turns for the workers and the
+// next batch is fetched as soon as a worker is free, so a slow handler
+// limited by WithHandlerConcurrency does not delay records of other
+// handlers. The lease of the fetched records which wait for the workers
+// is renewed with the lease timeout set by WithLeaseTimeout. If n is
+// greater than one, handlers must be safe for concurrent use and callbacks
+// can be called concurrently. Non-positive values are ignored.
+func WithConcurrency(n int) Option {
+	return func(c config) config {
+		if n > 0 {
+			c.concurrency = n
+		}
+
+		return c
+	}
+}
+
+// WithHandlerConcurrency limits the number of records processed at the same
+// time by the handler with the key. By default, the handler can use all
+// workers set by WithConcurrency. Set it to one to process records of the
+// handler one after another. Non-positive values are ignored.
+func WithHandlerConcurrency(handlerKey string, n int) Option {
+	return func(c config) config {
+		if n <= 0 {
+			return c
+		}
+
+		limits := make(map[string]int, len(c.handlerConcurrency)+1)
+
+		for key, limit := range c.handlerConcurrency {
+			limits[key] = limit
+		}
+
+		limits[handlerKey] = n
+		c.handlerConcurrency = limits
+
+		return c
+	}
+}
+
+// WithTableName sets custom name of the inbox table. Use it to run several
+// independent inboxes in one database. Migrations of the table are tracked
+// in the separate '<name>_schema' table.
+func WithTableName(name string) Option {
+	return func(c config) config {
+		c.tableName = name
+
+		return c
+	}
+}
+
+// WithSchema sets the database schema of the inbox table and its
+// migrations table. The schema is created if it does not exist. By default,
+// the current schema of the connection is used.
+func WithSchema(schema string) Option {
+	return func(c config) config {
+		c.schema = schema
+
+		return c
+	}
+}
+
+// WithStorage sets custom Storage of inbox records instead of the
+// Postgres table. Options of the table and fetching are not applied
+// to the custom Storage.
+func WithStorage(storage Storage) Option {
+	return func(c config) config {
+		c.storage = storage
+
+		return c
+	}
+}
+
 // WithRetention sets the retention configuration for inbox table.
 //
 // Arguments:
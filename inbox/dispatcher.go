@@ -0,0 +1,222 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxQueuedRecords limits the number of fetched records which wait for
+// the workers. Fetching is paused while the handlers of more records
+// are busy.
+const maxQueuedRecords = 1000
+
+// queuedRecord is the fetched record which waits for the worker.
+type queuedRecord struct {
+	record *Record
+	// claimedAt is the start of the lease of the record.
+	claimedAt time.Time
+}
+
+// dispatcher processes records with the worker pool of the concurrency
+// size. Each handler key has its own queue, at most handler concurrency
+// records of the key are processed at the same time and keys take turns
+// for free workers. The next batch is fetched as soon as a worker is free
+// and no queued record can take it, so a slow handler does not delay
+// records of other handlers. Records are saved once they are processed.
+// Fields are used only by the goroutine of run.
+type dispatcher struct {
+	inbox *Inbox
+	// keys is the order in which handler keys take turns.
+	keys   []string
+	queues map[string][]queuedRecord
+	// busy is the number of records of the handler key in process.
+	busy    map[string]int
+	queued  int
+	running int
+	// processed receives records from the workers.
+	processed chan *Record
+}
+
+func newDispatcher(i *Inbox) *dispatcher {
+	return &dispatcher{
+		inbox:     i,
+		queues:    make(map[string][]queuedRecord),
+		busy:      make(map[string]int),
+		processed: make(chan *Record, i.config.concurrency),
+	}
+}
+
+// run processes records until the table is drained or shutdown is started.
+// The table is checked again with the iteration rate while records are in
+// process. If ctx is canceled, records which are not processed yet are
+// released. Returns the first error, fetching is stopped after it.
+func (d *dispatcher) run(ctx context.Context) error {
+	var (
+		// The lease of queued records is renewed before it is expired.
+		renew = time.NewTicker(d.inbox.config.leaseTimeout / 4)
+		// The same as the ticker of the worker, the table is polled
+		// at most once a second.
+		poll    = time.NewTicker(max(d.inbox.config.iterationRate, time.Second))
+		drained bool
+		err     error
+	)
+
+	defer renew.Stop()
+	defer poll.Stop()
+
+	for {
+		d.schedule(ctx)
+
+		if err == nil && !drained && d.canFetch(ctx) {
+			drained, err = d.fetch(ctx)
+
+			continue
+		}
+
+		if d.running == 0 {
+			return err
+		}
+
+		var curr error
+
+		select {
+		case record := <-d.processed:
+			curr = d.save(ctx, record)
+		case <-renew.C:
+			curr = d.renew(ctx)
+		case <-poll.C:
+			drained = false
+		}
+
+		if err == nil {
+			err = curr
+		}
+	}
+}
+
+// canFetch reports whether the next batch can be fetched. The worker is
+// free only if the handlers of all queued records are busy.
+func (d *dispatcher) canFetch(ctx context.Context) bool {
+	return !d.inbox.lifecycle.IsStopped() &&
+		ctx.Err() == nil &&
+		d.running < d.inbox.config.concurrency &&
+		d.queued < maxQueuedRecords
+}
+
+// fetch fetches the next batch to the queues. Returns true if
+// there are no records to process.
+func (d *dispatcher) fetch(ctx context.Context) (bool, error) {
+	claimedAt := time.Now()
+
+	records, err := d.inbox.storage.Fetch(ctx, claimedAt.UTC())
+	if errors.Is(err, ErrNoRecords) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("records not fetched, %w", err)
+	}
+
+	for _, record := range records {
+		key := record.handlerKey
+
+		if _, ok := d.queues[key]; !ok {
+			d.keys = append(d.keys, key)
+		}
+
+		d.queues[key] = append(d.queues[key], queuedRecord{record: record, claimedAt: claimedAt})
+	}
+
+	d.queued += len(records)
+
+	return false, nil
+}
+
+// schedule starts processing of queued records while there are free
+// workers.
+func (d *dispatcher) schedule(ctx context.Context) {
+	for d.running < d.inbox.config.concurrency {
+		key, ok := d.next()
+		if !ok {
+			return
+		}
+
+		record := d.queues[key][0].record
+		d.queues[key] = d.queues[key][1:]
+
+		d.queued--
+		d.running++
+		d.busy[key]++
+
+		go func() {
+			d.inbox.processRecord(ctx, record)
+
+			d.processed <- record
+		}()
+	}
+}
+
+// next returns the next handler key which has queued records and is
+// not busy. The key takes the last turn after that.
+func (d *dispatcher) next() (string, bool) {
+	for n, key := range d.keys {
+		if len(d.queues[key]) == 0 || d.busy[key] >= d.inbox.handlerConcurrency(key) {
+			continue
+		}
+
+		d.keys = append(append(d.keys[:n:n], d.keys[n+1:]...), key)
+
+		return key, true
+	}
+
+	return "", false
+}
+
+// save saves statuses of the processed record and records processed
+// meanwhile. Statuses are saved even if processing is aborted.
+func (d *dispatcher) save(ctx context.Context, record *Record) error {
+	records := []*Record{record}
+
+	for len(d.processed) > 0 {
+		records = append(records, <-d.processed)
+	}
+
+	for _, record := range records {
+		d.running--
+		d.busy[record.handlerKey]--
+	}
+
+	return d.inbox.storage.Update(context.WithoutCancel(ctx), records)
+}
+
+// renew extends the lease of the queued records which are claimed before
+// the half of the lease timeout, so they are not claimed again while
+// they wait for the workers.
+func (d *dispatcher) renew(ctx context.Context) error {
+	var (
+		now      = time.Now()
+		deadline = now.Add(-d.inbox.config.leaseTimeout / 2)
+		records  []*Record
+	)
+
+	for _, queue := range d.queues {
+		for n := range queue {
+			if queue[n].claimedAt.Before(deadline) {
+				records = append(records, queue[n].record)
+				queue[n].claimedAt = now
+			}
+		}
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := d.inbox.storage.Update(context.WithoutCancel(ctx), records); err != nil {
+		return fmt.Errorf("lease of records not renewed, %w", err)
+	}
+
+	return nil
+}
@@ -1,5 +1,73 @@
 package inbox
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var ErrNoRecords = errors.New("no records in inbox table")
+
+// errNoHandler is the error message of the record which event type
+// or handler key is not registered.
+var errNoHandler = errors.New("handler is not registered")
+
+// errLeaseExpired is the error message of the record which lease is
+// expired too many times.
+var errLeaseExpired = errors.New("lease of the record is expired")
+
+// Skip is returned by the handler if the event is intentionally ignored.
+// The record is marked as Skipped and is not processed again. Wrap Skip
+// to save the reason as the error message of the record.
+//
+//	return fmt.Errorf("unsupported version %d, %w", version, inbox.Skip)
+var Skip = errors.New("event is skipped")
+
+// permanentError is the error after which the event can not be processed.
+type permanentError struct {
+	err error
+}
+
+// Permanent wraps the error of the handler to mark the record as 'dead'
+// at once without retries. Returns nil if err is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// retryAfterError is the error after which the event is processed again
+// with the delay chosen by the handler.
+type retryAfterError struct {
+	delay time.Duration
+	err   error
+}
+
+// RetryAfter wraps the error of the handler to process the record again
+// after the delay instead of the backoff delay, e.g. the delay from the
+// Retry-After header. The attempt is still counted, so the record is
+// marked as 'dead' after the max retry attempts. Returns nil if err is nil.
+func RetryAfter(delay time.Duration, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &retryAfterError{delay: delay, err: err}
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
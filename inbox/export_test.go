@@ -1,19 +1,36 @@
 package inbox
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-type Storage = defaultStorage
+type PostgresStorage = defaultStorage
 
-func NewStorage(conn *sql.DB) *Storage {
-	return newStorage(conn)
+func NewStorage(conn *sql.DB, opts ...Option) *PostgresStorage {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return newStorage(conn, cfg)
+}
+
+func LeaseTimeout(opts ...Option) time.Duration {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return cfg.leaseTimeout
 }
 
-func NewClient(storage *Storage, handlers map[string][]Handler) Client {
+func NewClient(storage Storage, handlers map[string][]Handler) Client {
 	return newClient(storage, handlers)
 }
 
@@ -116,12 +133,8 @@ func (r *Record) WithHandlerKey(key string) *Record {
 	return r.withHandlerKey(key)
 }
 
-func (r *Record) Status() Status {
-	return r.status
-}
-
-func (r *Record) Deadline() time.Time {
-	return r.attempt.nextAttempt
+func (i *Inbox) Iteration() error {
+	return i.iteration(context.Background())
 }
 
 func (i *Inbox) FailOrDead(record *Record, err error) *Record {
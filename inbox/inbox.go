@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/Melenium2/go-iobox/backoff"
+	"github.com/Melenium2/go-iobox/internal/lifecycle"
 	"github.com/Melenium2/go-iobox/retention"
 )
 
@@ -24,9 +25,11 @@ type Inbox struct {
 	config config
 
 	handlers  map[string][]Handler
-	storage   *defaultStorage
+	storage   Storage
 	backoff   *backoff.Backoff
 	retention *retention.Policy
+
+	lifecycle *lifecycle.Lifecycle
 }
 
 func NewInbox(registry *Registry, conn *sql.DB, opts ...Option) *Inbox {
@@ -36,12 +39,18 @@ func NewInbox(registry *Registry, conn *sql.DB, opts ...Option) *Inbox {
 		cfg = opt(cfg)
 	}
 
+	storage := cfg.storage
+	if storage == nil {
+		storage = newStorage(conn, cfg)
+	}
+
 	return &Inbox{
 		handlers:  registry.Handlers(),
-		storage:   newStorage(conn),
+		storage:   storage,
 		config:    cfg,
 		backoff:   backoff.NewBackoff(),
-		retention: retention.NewPolicy(conn, tableName, cfg.retention),
+		retention: retention.NewPurgerPolicy(storage, cfg.retention),
+		lifecycle: lifecycle.New(),
 	}
 }
 
@@ -52,19 +61,44 @@ func (i *Inbox) Writer() Client {
 
 // Start creates new inbox table if it not created and starts worker
 // which process records from the table. To stop inbox worker, you can
-// call context close() function.
+// call context close() function, but it does not wait for in-flight
+// handlers. Use Shutdown to stop the worker gracefully.
 func (i *Inbox) Start(ctx context.Context) error {
 	if err := i.storage.InitInboxTable(ctx); err != nil {
 		return err
 	}
 
-	go i.run(ctx)
-	go i.retention.Start(ctx)
+	// In-flight handlers are executed with context that is not
+	// canceled with ctx, so they can be finished during shutdown.
+	inFlight := i.lifecycle.InFlight(ctx)
+
+	i.lifecycle.Go(func() {
+		i.run(ctx, inFlight)
+	})
+
+	// Erasing is canceled after shutdown deadline, so it does not
+	// delay Shutdown.
+	purge := i.lifecycle.Context(ctx)
+
+	i.lifecycle.Go(func() {
+		i.retention.Start(purge)
+	})
 
 	return nil
 }
 
-func (i *Inbox) run(ctx context.Context) {
+// Shutdown stops the worker gracefully. The worker stops fetching new
+// records and waits for in-flight handlers until the context is done.
+// After that handlers are canceled and records that are not processed
+// are released back to the inbox table, so they will be processed by
+// another worker.
+func (i *Inbox) Shutdown(ctx context.Context) error {
+	i.retention.Stop()
+
+	return i.lifecycle.Shutdown(ctx)
+}
+
+func (i *Inbox) run(ctx, inFlight context.Context) {
 	var (
 		backoffConfig = backoff.Config{
 			Min: time.Second,
@@ -74,11 +108,12 @@ func (i *Inbox) run(ctx context.Context) {
 	)
 
 	ticker := backoff.NewTicker(bf, i.config.iterationRate, i.config.iterationSeed)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			err := i.iteration() //nolint:contextcheck
+			err := i.iteration(inFlight) //nolint:contextcheck
 			if errors.Is(err, ErrNoRecords) {
 				continue
 			}
@@ -88,51 +123,149 @@ func (i *Inbox) run(ctx context.Context) {
 			}
 		case <-ctx.Done():
 			return
+		case <-i.lifecycle.Stopped():
+			return
 		}
 	}
 }
 
-// iteration fetches all incoming events from a temporary table
-// and trying to process it. In some cases the worker can not process
-// incoming events. 1) If we received an unknown event_type. 2) If the handler with
-// required key not found in the Registry. In this cases we skip
-// current record and sets its status to Null. In the next iteration
-// we again try to handle the event. In other cases we set Fail or
-// Done status to the record depends on in the result of handler.
-func (i *Inbox) iteration() error {
-	ctx := context.Background()
+// iteration fetches incoming events from a temporary table batch by
+// batch and trying to process it until the table is drained. In some
+// cases the worker can not process incoming events. 1) If we received
+// an unknown event_type. 2) If the handler with required key not found
+// in the Registry. In this cases we postpone the record with Failed status
+// and the delayed next attempt, so it does not block newer records. The
+// attempt is not counted, so the event is handled when the handler is
+// registered again. In other cases we set Fail or Done status to the record depends on in
+// the result of handler. If ctx is canceled, the records that are not
+// processed yet are released with Null status. Records are processed
+// concurrently by the dispatcher if the concurrency is greater than one.
+func (i *Inbox) iteration(ctx context.Context) error {
+	if i.config.concurrency > 1 {
+		return newDispatcher(i).run(ctx)
+	}
+
+	for !i.lifecycle.IsStopped() {
+		more, err := i.processBatch(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			return nil
+		}
+	}
 
+	return nil
+}
+
+// processBatch processes the next batch of records one after another and
+// updates their statuses. Returns true if the batch is not empty and no
+// record is released, so the table may contain more records to process.
+// The size of the batch is not compared with the batch size option,
+// because a custom Storage has its own batch size.
+func (i *Inbox) processBatch(ctx context.Context) (bool, error) {
 	records, err := i.storage.Fetch(ctx, time.Now().UTC())
+	if errors.Is(err, ErrNoRecords) {
+		return false, nil
+	}
+
 	if err != nil {
-		return fmt.Errorf("records not fetched, %w", err)
+		return false, fmt.Errorf("records not fetched, %w", err)
 	}
 
 	for _, record := range records {
-		handlers, ok := i.handlers[record.eventType]
-		if !ok {
-			record.Null()
+		i.processRecord(ctx, record)
+	}
 
-			continue
+	// Statuses are saved even if processing is aborted.
+	if err = i.storage.Update(context.WithoutCancel(ctx), records); err != nil {
+		return false, err
+	}
+
+	for _, record := range records {
+		if record.status == Null {
+			// Processing is aborted, released records are fetched
+			// again at once, so they wait for the next iteration.
+			return false, nil
 		}
+	}
 
-		handler, ok := i.lookForHandler(record.handlerKey, handlers)
-		if !ok {
-			record.Null()
+	return true, nil
+}
 
-			continue
-		}
+// handlerConcurrency returns the max number of records of the handler
+// processed at the same time.
+func (i *Inbox) handlerConcurrency(handlerKey string) int {
+	if limit, ok := i.config.handlerConcurrency[handlerKey]; ok {
+		return min(limit, i.config.concurrency)
+	}
 
-		if err = i.process(ctx, handler, record.payload); err != nil {
-			// function mutate record inside itself.
-			_ = i.failOrDead(record, err)
+	return i.config.concurrency
+}
+
+// processRecord processes the record with its handler. If the handler
+// is not found, the record is postponed.
+func (i *Inbox) processRecord(ctx context.Context, record *Record) {
+	if ctx.Err() != nil {
+		// Processing is aborted, release the record.
+		record.Null()
+
+		return
+	}
+
+	if record.Attempt() >= i.config.maxRetryAttempts {
+		// The worker died on the record too many times, so each
+		// expired lease is counted as failed attempt.
+		record.attempt.message = errLeaseExpired.Error()
+		record.Dead()
+
+		i.config.onDead(record.id, record.attempt.message)
+
+		return
+	}
+
+	handlers, ok := i.handlers[record.eventType]
+	if !ok {
+		i.postpone(record)
+
+		return
+	}
+
+	handler, ok := i.lookForHandler(record.handlerKey, handlers)
+	if !ok {
+		i.postpone(record)
+
+		return
+	}
 
-			continue
+	if err := i.process(ctx, handler, record); err != nil {
+		if ctx.Err() != nil {
+			// Handler is canceled by shutdown, it is
+			// not counted as failed attempt.
+			record.Null()
+
+			return
 		}
 
-		record.Done()
+		// function mutate record inside itself.
+		_ = i.failOrDead(record, err)
+
+		return
 	}
 
-	return i.storage.Update(ctx, records)
+	record.Done()
+}
+
+// postpone sets Failed status to the record without the handler and delays
+// its next attempt. Records are fetched in order of event date, so released
+// records would be fetched first again and block newer records once there
+// are a batch of them. The attempt is not counted.
+func (i *Inbox) postpone(record *Record) {
+	record.status = Failed
+	record.attempt.message = errNoHandler.Error()
+
+	record.CalcNewDeadline(noHandlerDelay)
 }
 
 func (i *Inbox) lookForHandler(handlerKey string, handlers []Handler) (Handler, bool) {
@@ -145,19 +278,30 @@ func (i *Inbox) lookForHandler(handlerKey string, handlers []Handler) (Handler,
 	return nil, false
 }
 
-func (i *Inbox) process(ctx context.Context, handler Handler, payload []byte) error {
-	ctx, cancel := context.WithTimeout(ctx, i.config.handlerTimeout)
+func (i *Inbox) process(ctx context.Context, handler Handler, record *Record) error {
+	ctx, cancel := context.WithTimeout(withEvent(ctx, record), i.config.handlerTimeout)
 	defer cancel()
 
-	return handler.Process(ctx, payload)
+	return handler.Process(ctx, record.payload)
 }
 
+// failOrDead sets the status of the record by the error of the handler.
+// Skip marks the record as Skipped, Permanent marks it as Dead at once,
+// RetryAfter replaces the backoff delay of the next attempt.
 func (i *Inbox) failOrDead(record *Record, err error) *Record {
+	if errors.Is(err, Skip) {
+		record.Skip(err)
+
+		return record
+	}
+
 	record.Fail(err)
 
 	attempt := record.Attempt()
 
-	if attempt >= i.config.maxRetryAttempts {
+	var permanent *permanentError
+
+	if errors.As(err, &permanent) || attempt >= i.config.maxRetryAttempts {
 		record.Dead()
 
 		i.config.onDead(record.id, err.Error())
@@ -167,6 +311,12 @@ func (i *Inbox) failOrDead(record *Record, err error) *Record {
 
 	dur := i.backoff.Next(attempt)
 
+	var retryAfter *retryAfterError
+
+	if errors.As(err, &retryAfter) {
+		dur = retryAfter.delay
+	}
+
 	record.CalcNewDeadline(dur)
 
 	return record
@@ -1,13 +1,22 @@
 package inbox_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/mocks"
+	"github.com/Melenium2/go-iobox/inbox/storagetest"
 )
 
 func TestInbox_FailOrDead(t *testing.T) {
@@ -27,7 +36,7 @@ func TestInbox_FailOrDead(t *testing.T) {
 		output := svc.FailOrDead(input, errors.New("err"))
 		assert.Equal(t, 4, output.Attempt())
 		assert.Equal(t, inbox.Failed, output.Status())
-		assert.Greater(t, output.Deadline(), time.Now().UTC())
+		assert.Greater(t, output.NextAttempt(), time.Now().UTC())
 	})
 
 	t.Run("should mark record as 'dead'", func(t *testing.T) {
@@ -37,4 +46,615 @@ func TestInbox_FailOrDead(t *testing.T) {
 		assert.Equal(t, 5, output.Attempt())
 		assert.Equal(t, inbox.Dead, output.Status())
 	})
+
+	t.Run("should mark record as 'dead' at once if error is permanent", func(t *testing.T) {
+		input := inbox.RecordWithAttempt(0, inbox.Progress)
+
+		output := svc.FailOrDead(input, inbox.Permanent(errors.New("invalid payload")))
+		assert.Equal(t, 1, output.Attempt())
+		assert.Equal(t, inbox.Dead, output.Status())
+		assert.Equal(t, "invalid payload", output.ErrorMessage())
+		assert.True(t, output.NextAttempt().IsZero())
+	})
+
+	t.Run("should setup deadline from retry after error", func(t *testing.T) {
+		input := inbox.RecordWithAttempt(0, inbox.Progress)
+
+		output := svc.FailOrDead(input, inbox.RetryAfter(time.Hour, errors.New("too many requests")))
+		assert.Equal(t, 1, output.Attempt())
+		assert.Equal(t, inbox.Failed, output.Status())
+		assert.Equal(t, "too many requests", output.ErrorMessage())
+		assert.WithinDuration(t, time.Now().UTC().Add(time.Hour), output.NextAttempt(), time.Second)
+	})
+
+	t.Run("should mark record as 'dead' after retry after error on last attempt", func(t *testing.T) {
+		input := inbox.RecordWithAttempt(4, inbox.Failed)
+
+		output := svc.FailOrDead(input, inbox.RetryAfter(time.Hour, errors.New("too many requests")))
+		assert.Equal(t, inbox.Dead, output.Status())
+	})
+
+	t.Run("should skip record", func(t *testing.T) {
+		input := inbox.RecordWithAttempt(1, inbox.Progress)
+
+		output := svc.FailOrDead(input, fmt.Errorf("unsupported version, %w", inbox.Skip))
+		assert.Equal(t, 1, output.Attempt())
+		assert.Equal(t, inbox.Skipped, output.Status())
+		assert.Equal(t, "unsupported version, event is skipped", output.ErrorMessage())
+		assert.True(t, output.NextAttempt().IsZero())
+	})
+}
+
+func TestWithLeaseTimeout(t *testing.T) {
+	t.Run("should set lease timeout", func(t *testing.T) {
+		assert.Equal(t, time.Minute, inbox.LeaseTimeout(inbox.WithLeaseTimeout(time.Minute)))
+	})
+
+	t.Run("should ignore non-positive lease timeout", func(t *testing.T) {
+		assert.Equal(t, inbox.DefaultLeaseTimeout, inbox.LeaseTimeout(inbox.WithLeaseTimeout(0)))
+		assert.Equal(t, inbox.DefaultLeaseTimeout, inbox.LeaseTimeout(inbox.WithLeaseTimeout(-time.Minute)))
+	})
+}
+
+func TestInbox_WithStorage(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		handler  = mocks.NewHandler(t)
+		registry = inbox.NewRegistry()
+		storage  = storagetest.NewMemoryStorage()
+	)
+
+	handler.On("Key").Return("1")
+	handler.On("Process", mock.Anything, []byte("{}")).Return(nil).Once()
+
+	registry.On("1", handler)
+
+	svc := inbox.NewInbox(registry, nil, inbox.WithStorage(storage))
+
+	record, err := inbox.NewRecord(inbox.ID1(), "1", []byte("{}"))
+	require.NoError(t, err)
+
+	err = svc.Writer().WriteInbox(ctx, record)
+	require.NoError(t, err)
+
+	err = svc.Iteration()
+	require.NoError(t, err)
+
+	_, err = storage.Fetch(ctx, time.Now().UTC())
+	assert.ErrorIs(t, err, inbox.ErrNoRecords)
+}
+
+func TestInbox_Iteration_Should_process_all_batches(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		handler  = &slowHandler{key: "1"}
+		registry = inbox.NewRegistry()
+		storage  = storagetest.NewMemoryStorage()
+		count    = inbox.DefaultBatchSize*2 + 1
+	)
+
+	registry.On("1", handler)
+
+	svc := inbox.NewInbox(registry, nil, inbox.WithStorage(storage))
+
+	for i := 0; i < count; i++ {
+		record, err := inbox.NewRecord(uuid.New(), "1", []byte("{}"))
+		require.NoError(t, err)
+
+		err = svc.Writer().WriteInbox(ctx, record)
+		require.NoError(t, err)
+	}
+
+	err := svc.Iteration()
+	require.NoError(t, err)
+
+	assert.EqualValues(t, count, handler.processed.Load())
+
+	_, err = storage.Fetch(ctx, time.Now().UTC())
+	assert.ErrorIs(t, err, inbox.ErrNoRecords)
+}
+
+func TestInbox_Iteration_Should_process_all_batches_of_storage_with_other_batch_size(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		handler  = &slowHandler{key: "1"}
+		registry = inbox.NewRegistry()
+		storage  = storagetest.NewMemoryStorage()
+		count    = inbox.DefaultBatchSize*2 + 1
+	)
+
+	registry.On("1", handler)
+
+	// The batch size option is not applied to the custom storage, which
+	// fetches batches of the default size.
+	svc := inbox.NewInbox(registry, nil, inbox.WithStorage(storage), inbox.WithBatchSize(inbox.DefaultBatchSize/2))
+
+	for i := 0; i < count; i++ {
+		record, err := inbox.NewRecord(uuid.New(), "1", []byte("{}"))
+		require.NoError(t, err)
+
+		err = svc.Writer().WriteInbox(ctx, record)
+		require.NoError(t, err)
+	}
+
+	err := svc.Iteration()
+	require.NoError(t, err)
+
+	assert.EqualValues(t, count, handler.processed.Load())
+}
+
+func TestInbox_Iteration_Should_not_block_records_by_records_without_handler(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		handler  = &slowHandler{key: "1"}
+		registry = inbox.NewRegistry()
+		storage  = storagetest.NewMemoryStorage()
+		date     = time.Now().UTC().Add(-time.Hour)
+	)
+
+	registry.On("1", handler)
+
+	svc := inbox.NewInbox(registry, nil, inbox.WithStorage(storage))
+
+	// The handler of the older records is removed.
+	for i := 0; i <= inbox.DefaultBatchSize; i++ {
+		err := storage.Insert(ctx, inbox.RestoreRecord(inbox.RecordState{
+			ID:         uuid.New(),
+			EventType:  "1",
+			HandlerKey: "removed",
+			Payload:    []byte("{}"),
+			EventDate:  date.Add(time.Duration(i) * time.Second),
+		}))
+		require.NoError(t, err)
+	}
+
+	record, err := inbox.NewRecord(inbox.ID1(), "1", []byte("{}"))
+	require.NoError(t, err)
+
+	err = svc.Writer().WriteInbox(ctx, record)
+	require.NoError(t, err)
+
+	err = svc.Iteration()
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 1, handler.processed.Load())
+
+	// Records without handler are postponed.
+	_, err = storage.Fetch(ctx, time.Now().UTC())
+	assert.ErrorIs(t, err, inbox.ErrNoRecords)
+}
+
+// laterStorage fetches records as if the time is shifted forward.
+type laterStorage struct {
+	*storagetest.MemoryStorage
+	shift time.Duration
+}
+
+func (s *laterStorage) Fetch(ctx context.Context, fetchTime time.Time) ([]*inbox.Record, error) {
+	return s.MemoryStorage.Fetch(ctx, fetchTime.Add(s.shift))
+}
+
+func TestInbox_Iteration_Should_mark_record_as_dead_if_lease_expired_too_many_times(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		handler  = mocks.NewHandler(t)
+		registry = inbox.NewRegistry()
+		storage  = storagetest.NewMemoryStorage()
+		dead     []uuid.UUID
+	)
+
+	handler.On("Key").Return("1")
+
+	registry.On("1", handler)
+
+	svc := inbox.NewInbox(
+		registry,
+		nil,
+		inbox.WithStorage(&laterStorage{MemoryStorage: storage, shift: inbox.DefaultLeaseTimeout + time.Minute}),
+		inbox.WithMaxRetryAttempt(1),
+		inbox.OnDeadCallback(func(eventID uuid.UUID, _ string) {
+			dead = append(dead, eventID)
+		}),
+	)
+
+	record, err := inbox.NewRecord(inbox.ID1(), "1", []byte("{}"))
+	require.NoError(t, err)
+
+	err = svc.Writer().WriteInbox(ctx, record)
+	require.NoError(t, err)
+
+	// The worker died after the record is claimed.
+	_, err = storage.Fetch(ctx, time.Now().UTC())
+	require.NoError(t, err)
+
+	err = svc.Iteration()
+	require.NoError(t, err)
+
+	assert.Equal(t, []uuid.UUID{inbox.ID1()}, dead)
+
+	_, err = storage.Fetch(ctx, time.Now().UTC().Add(inbox.DefaultLeaseTimeout+time.Minute))
+	assert.ErrorIs(t, err, inbox.ErrNoRecords)
+}
+
+// slowHandler counts the max number of records processed at the same time.
+type slowHandler struct {
+	key   string
+	delay time.Duration
+	// block holds the handler until it is closed.
+	block       chan struct{}
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+	processed   atomic.Int64
+}
+
+func (h *slowHandler) Key() string {
+	return h.key
+}
+
+func (h *slowHandler) Process(context.Context, []byte) error {
+	curr := h.inFlight.Add(1)
+	defer h.inFlight.Add(-1)
+
+	for {
+		prev := h.maxInFlight.Load()
+		if curr <= prev || h.maxInFlight.CompareAndSwap(prev, curr) {
+			break
+		}
+	}
+
+	time.Sleep(h.delay)
+
+	if h.block != nil {
+		<-h.block
+	}
+
+	h.processed.Add(1)
+
+	return nil
+}
+
+func TestInbox_Concurrency(t *testing.T) {
+	write := func(t *testing.T, svc *inbox.Inbox, eventType string, n int) {
+		t.Helper()
+
+		for range n {
+			record, err := inbox.NewRecord(uuid.New(), eventType, []byte("{}"))
+			require.NoError(t, err)
+
+			err = svc.Writer().WriteInbox(context.Background(), record)
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("should process records of the handler concurrently", func(t *testing.T) {
+		var (
+			handler  = &slowHandler{key: "1", delay: 50 * time.Millisecond}
+			registry = inbox.NewRegistry()
+		)
+
+		registry.On("1", handler)
+
+		svc := inbox.NewInbox(registry, nil,
+			inbox.WithStorage(storagetest.NewMemoryStorage()),
+			inbox.WithConcurrency(4),
+		)
+
+		write(t, svc, "1", 8)
+
+		err := svc.Iteration()
+		require.NoError(t, err)
+		assert.Equal(t, int64(8), handler.processed.Load())
+		assert.Equal(t, int64(4), handler.maxInFlight.Load())
+	})
+
+	t.Run("should not starve handler by slow handler", func(t *testing.T) {
+		var (
+			slow     = &slowHandler{key: "slow", delay: 100 * time.Millisecond}
+			fast     = &slowHandler{key: "fast"}
+			registry = inbox.NewRegistry()
+		)
+
+		registry.On("slow", slow)
+		registry.On("fast", fast)
+
+		svc := inbox.NewInbox(registry, nil,
+			inbox.WithStorage(storagetest.NewMemoryStorage()),
+			inbox.WithConcurrency(2),
+			inbox.WithHandlerConcurrency("slow", 1),
+		)
+
+		write(t, svc, "slow", 4)
+		write(t, svc, "fast", 4)
+
+		done := make(chan error)
+
+		go func() {
+			done <- svc.Iteration()
+		}()
+
+		// Records of the fast handler are processed while the slow
+		// handler processes its first record.
+		assert.Eventually(t, func() bool {
+			return fast.processed.Load() == 4
+		}, 150*time.Millisecond, 5*time.Millisecond)
+
+		require.NoError(t, <-done)
+		assert.Equal(t, int64(4), slow.processed.Load())
+		assert.Equal(t, int64(1), slow.maxInFlight.Load())
+	})
+
+	t.Run("should process records of handler while slow handler is busy", func(t *testing.T) {
+		var (
+			slow     = &slowHandler{key: "slow", block: make(chan struct{})}
+			fast     = &slowHandler{key: "fast"}
+			registry = inbox.NewRegistry()
+		)
+
+		registry.On("slow", slow)
+		registry.On("fast", fast)
+
+		svc := inbox.NewInbox(registry, nil,
+			inbox.WithStorage(storagetest.NewMemoryStorage()),
+			inbox.WithConcurrency(2),
+			inbox.WithHandlerConcurrency("slow", 1),
+		)
+
+		// The first batch contains only records of the slow handler.
+		write(t, svc, "slow", inbox.DefaultBatchSize+1)
+		write(t, svc, "fast", 4)
+
+		done := make(chan error)
+
+		go func() {
+			done <- svc.Iteration()
+		}()
+
+		assert.Eventually(t, func() bool {
+			return fast.processed.Load() == 4
+		}, time.Second, 5*time.Millisecond)
+
+		assert.Zero(t, slow.processed.Load())
+
+		close(slow.block)
+
+		require.NoError(t, <-done)
+		assert.Equal(t, int64(inbox.DefaultBatchSize+1), slow.processed.Load())
+		assert.Equal(t, int64(1), slow.maxInFlight.Load())
+	})
+
+	t.Run("should renew lease of records waiting for busy handler", func(t *testing.T) {
+		var (
+			slow     = &slowHandler{key: "slow", delay: 200 * time.Millisecond}
+			registry = inbox.NewRegistry()
+			storage  = newRecordingStorage()
+		)
+
+		registry.On("slow", slow)
+
+		svc := inbox.NewInbox(registry, nil,
+			inbox.WithStorage(storage),
+			inbox.WithConcurrency(2),
+			inbox.WithHandlerConcurrency("slow", 1),
+			inbox.WithLeaseTimeout(100*time.Millisecond),
+		)
+
+		write(t, svc, "slow", 3)
+
+		err := svc.Iteration()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(3), slow.processed.Load())
+		assert.Positive(t, storage.count(inbox.Progress))
+	})
+}
+
+// recordingStorage records statuses and attempts of the updated records.
+type recordingStorage struct {
+	*storagetest.MemoryStorage
+
+	mu      sync.Mutex
+	updates []inbox.RecordState
+}
+
+func newRecordingStorage() *recordingStorage {
+	return &recordingStorage{MemoryStorage: storagetest.NewMemoryStorage()}
+}
+
+func (s *recordingStorage) Update(ctx context.Context, records []*inbox.Record) error {
+	s.mu.Lock()
+
+	for _, record := range records {
+		s.updates = append(s.updates, inbox.RecordState{
+			ID:      record.ID(),
+			Status:  record.Status(),
+			Attempt: record.Attempt(),
+		})
+	}
+
+	s.mu.Unlock()
+
+	return s.MemoryStorage.Update(ctx, records)
+}
+
+// count returns the number of updates with the status.
+func (s *recordingStorage) count(status inbox.Status) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+
+	for _, update := range s.updates {
+		if update.Status == status {
+			count++
+		}
+	}
+
+	return count
+}
+
+// last returns the last update of the record.
+func (s *recordingStorage) last(id uuid.UUID) (inbox.RecordState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n := len(s.updates) - 1; n >= 0; n-- {
+		if s.updates[n].ID == id {
+			return s.updates[n], true
+		}
+	}
+
+	return inbox.RecordState{}, false
+}
+
+// blockHandler blocks processing until release is closed or
+// the context is done.
+type blockHandler struct {
+	key      string
+	started  chan struct{}
+	once     sync.Once
+	release  chan struct{}
+	canceled atomic.Bool
+}
+
+func newBlockHandler(key string) *blockHandler {
+	return &blockHandler{
+		key:     key,
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+}
+
+func (h *blockHandler) Key() string {
+	return h.key
+}
+
+func (h *blockHandler) Process(ctx context.Context, _ []byte) error {
+	h.once.Do(func() {
+		close(h.started)
+	})
+
+	select {
+	case <-h.release:
+		return nil
+	case <-ctx.Done():
+		h.canceled.Store(true)
+
+		return ctx.Err()
+	}
+}
+
+func TestInbox_Shutdown(t *testing.T) {
+	t.Run("should return immediately if inbox is not started", func(t *testing.T) {
+		svc := inbox.NewInbox(inbox.NewRegistry(), nil)
+
+		err := svc.Shutdown(context.Background())
+		assert.NoError(t, err)
+	})
+
+	write := func(t *testing.T, svc *inbox.Inbox, n int) []uuid.UUID {
+		t.Helper()
+
+		ids := make([]uuid.UUID, 0, n)
+
+		for range n {
+			record, err := inbox.NewRecord(uuid.New(), "1", []byte("{}"))
+			require.NoError(t, err)
+
+			err = svc.Writer().WriteInbox(context.Background(), record)
+			require.NoError(t, err)
+
+			ids = append(ids, record.ID())
+		}
+
+		return ids
+	}
+
+	t.Run("should wait for in-flight handler", func(t *testing.T) {
+		var (
+			handler  = newBlockHandler("1")
+			registry = inbox.NewRegistry()
+			storage  = newRecordingStorage()
+		)
+
+		registry.On("1", handler)
+
+		svc := inbox.NewInbox(registry, nil, inbox.WithStorage(storage))
+
+		ids := write(t, svc, 1)
+
+		err := svc.Start(context.Background())
+		require.NoError(t, err)
+
+		<-handler.started
+
+		done := make(chan error)
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			done <- svc.Shutdown(ctx)
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("shutdown returned before the handler is finished")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(handler.release)
+
+		require.NoError(t, <-done)
+		assert.False(t, handler.canceled.Load())
+
+		state, ok := storage.last(ids[0])
+		require.True(t, ok)
+		assert.Equal(t, inbox.Done, state.Status)
+	})
+
+	t.Run("should release not processed records after deadline", func(t *testing.T) {
+		var (
+			handler  = newBlockHandler("1")
+			registry = inbox.NewRegistry()
+			storage  = newRecordingStorage()
+		)
+
+		registry.On("1", handler)
+
+		// Records wait for the busy handler in the queue.
+		svc := inbox.NewInbox(registry, nil,
+			inbox.WithStorage(storage),
+			inbox.WithConcurrency(2),
+			inbox.WithHandlerConcurrency("1", 1),
+		)
+
+		ids := write(t, svc, 3)
+
+		err := svc.Start(context.Background())
+		require.NoError(t, err)
+
+		<-handler.started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err = svc.Shutdown(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.True(t, handler.canceled.Load())
+
+		for _, id := range ids {
+			state, ok := storage.last(id)
+			require.True(t, ok)
+			assert.Equal(t, inbox.Null, state.Status)
+			assert.Equal(t, 0, state.Attempt)
+		}
+
+		records, err := storage.Fetch(context.Background(), time.Now().UTC())
+		require.NoError(t, err)
+		require.Len(t, records, len(ids))
+
+		for _, record := range records {
+			assert.Equal(t, 0, record.Attempt())
+		}
+	})
 }
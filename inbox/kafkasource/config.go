@@ -0,0 +1,187 @@
+package kafkasource
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/Melenium2/go-iobox/backoff"
+)
+
+const (
+	// DefaultWriteTimeout is the timeout after which writing of the
+	// message to the inbox table is canceled.
+	DefaultWriteTimeout = 5 * time.Second
+	// DefaultRetryMin is the min delay before writing of the failed
+	// message is retried.
+	DefaultRetryMin = time.Second
+	// DefaultRetryMax is the max delay before writing of the failed
+	// message is retried.
+	DefaultRetryMax = 30 * time.Second
+	// HeaderEventType is the header with the event type of the message.
+	HeaderEventType = "event-type"
+	// HeaderEventID is the header with the event ID of the message.
+	HeaderEventID = "event-id"
+)
+
+type (
+	// IDExtractor returns the event ID of the message.
+	IDExtractor func(kafka.Message) (uuid.UUID, error)
+	// EventTypeExtractor returns the event type of the message.
+	EventTypeExtractor func(kafka.Message) (string, error)
+	// ErrorCallback prototype of function that is called if errors occurs
+	// during consuming.
+	ErrorCallback func(err error)
+)
+
+func nopErrorCallback(error) {}
+
+// defaultID parses the event ID from the HeaderEventID header, if the
+// header is not set the ID is parsed from the message key.
+func defaultID(msg kafka.Message) (uuid.UUID, error) {
+	if _, ok := headerValue(msg, HeaderEventID); ok {
+		return IDFromHeader(HeaderEventID)(msg)
+	}
+
+	return IDFromKey(msg)
+}
+
+// IDFromKey parses the event ID from the message key.
+func IDFromKey(msg kafka.Message) (uuid.UUID, error) {
+	id, err := uuid.ParseBytes(msg.Key)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("incorrect message key %q, %w", msg.Key, err)
+	}
+
+	return id, nil
+}
+
+// IDFromHeader parses the event ID from the header.
+func IDFromHeader(header string) IDExtractor {
+	return func(msg kafka.Message) (uuid.UUID, error) {
+		value, ok := headerValue(msg, header)
+		if !ok {
+			return uuid.Nil, fmt.Errorf("header %q not found", header)
+		}
+
+		id, err := uuid.ParseBytes(value)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("incorrect event id in header %q, %w", header, err)
+		}
+
+		return id, nil
+	}
+}
+
+// EventTypeFromTopic uses the topic as the event type.
+func EventTypeFromTopic(msg kafka.Message) (string, error) {
+	return msg.Topic, nil
+}
+
+// EventTypeFromHeader takes the event type from the header.
+func EventTypeFromHeader(header string) EventTypeExtractor {
+	return func(msg kafka.Message) (string, error) {
+		value, ok := headerValue(msg, header)
+		if !ok {
+			return "", fmt.Errorf("header %q not found", header)
+		}
+
+		return string(value), nil
+	}
+}
+
+func headerValue(msg kafka.Message, header string) ([]byte, bool) {
+	for _, h := range msg.Headers {
+		if h.Key == header {
+			return h.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+type config struct {
+	writeTimeout time.Duration
+	retry        backoff.Config
+	id           IDExtractor
+	eventType    EventTypeExtractor
+	onError      ErrorCallback
+}
+
+func defaultConfig() config {
+	return config{
+		writeTimeout: DefaultWriteTimeout,
+		retry: backoff.Config{
+			Min: DefaultRetryMin,
+			Max: DefaultRetryMax,
+		},
+		id:        defaultID,
+		eventType: EventTypeFromHeader(HeaderEventType),
+		onError:   nopErrorCallback,
+	}
+}
+
+// Option sets specific configuration to the Source.
+type Option func(config) config
+
+// WithWriteTimeout sets the timeout of writing the message to
+// the inbox table.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c config) config {
+		c.writeTimeout = timeout
+
+		return c
+	}
+}
+
+// WithRetryBackoff sets the min and max delay before writing of the
+// failed message is retried. The delay grows with the number of
+// consecutive failures.
+func WithRetryBackoff(minDelay, maxDelay time.Duration) Option {
+	return func(c config) config {
+		c.retry = backoff.Config{
+			Min: minDelay,
+			Max: maxDelay,
+		}
+
+		return c
+	}
+}
+
+// WithIDExtractor sets the function that returns the event ID of the
+// message. By default, the ID is parsed from the HeaderEventID header
+// or from the message key if the header is not set.
+func WithIDExtractor(extractor IDExtractor) Option {
+	return func(c config) config {
+		if extractor != nil {
+			c.id = extractor
+		}
+
+		return c
+	}
+}
+
+// WithEventTypeExtractor sets the function that returns the event type
+// of the message. By default, the event type is taken from the
+// HeaderEventType header.
+func WithEventTypeExtractor(extractor EventTypeExtractor) Option {
+	return func(c config) config {
+		if extractor != nil {
+			c.eventType = extractor
+		}
+
+		return c
+	}
+}
+
+// OnErrorCallback sets the callback which is called if error
+// occurs during consuming.
+func OnErrorCallback(callback ErrorCallback) Option {
+	return func(c config) config {
+		c.onError = callback
+
+		return c
+	}
+}
@@ -0,0 +1,194 @@
+// Package kafkasource consumes events from Kafka and writes them
+// to the inbox table.
+//
+// The offset of the message is committed only after the message is
+// written to the inbox table. If writing fails, it is retried with
+// backoff, so messages of the partition are written in order and
+// never lost.
+package kafkasource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/Melenium2/go-iobox/backoff"
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/internal/lifecycle"
+)
+
+// Reader reads messages of the consumer group. *kafka.Reader with
+// the GroupID implements the interface.
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Source reads messages from Kafka and writes them to the inbox table.
+type Source struct {
+	reader Reader
+	writer inbox.Client
+	config config
+
+	backoff *backoff.Backoff
+
+	lifecycle *lifecycle.Lifecycle
+}
+
+// New creates new Source reading messages with the reader. Use
+// inbox.Inbox.Writer as the writer.
+func New(reader Reader, writer inbox.Client, opts ...Option) *Source {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return &Source{
+		reader:    reader,
+		writer:    writer,
+		config:    cfg,
+		backoff:   backoff.NewBackoff(cfg.retry),
+		lifecycle: lifecycle.New(),
+	}
+}
+
+// Start starts reading messages. Closing the context stops reading
+// without waiting for in-flight messages, use Shutdown to stop the
+// source gracefully.
+func (s *Source) Start(ctx context.Context) error {
+	// In-flight messages are written with context that is not
+	// canceled with ctx, so they can be finished during shutdown.
+	inFlight := s.lifecycle.InFlight(ctx)
+
+	s.lifecycle.Go(func() {
+		s.run(ctx, inFlight)
+	})
+
+	return nil
+}
+
+// Shutdown stops reading and waits for the in-flight message until
+// the context is done. The offset of the message that is not written
+// is not committed, so the message is read again after restart.
+func (s *Source) Shutdown(ctx context.Context) error {
+	return s.lifecycle.Shutdown(ctx)
+}
+
+func (s *Source) run(ctx, inFlight context.Context) {
+	// fetchCtx is canceled on shutdown, so the source does not
+	// wait for new messages.
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-s.lifecycle.Stopped():
+			cancel()
+		case <-fetchCtx.Done():
+		}
+	}()
+
+	for {
+		msg, err := s.reader.FetchMessage(fetchCtx)
+		if fetchCtx.Err() != nil || errors.Is(err, io.EOF) {
+			return
+		}
+
+		if err != nil {
+			s.config.onError(fmt.Errorf("message not fetched, %w", err))
+
+			if !s.wait(fetchCtx, 0) {
+				return
+			}
+
+			continue
+		}
+
+		if !s.handle(fetchCtx, inFlight, msg) {
+			return
+		}
+	}
+}
+
+// handle writes the message to the inbox table and commits its offset.
+// Writing is retried until it succeeds, because the offset of the next
+// messages can not be committed before. Message that can not be mapped
+// to the inbox record is skipped, because it never succeeds. Returns
+// false if the source is stopped before the message is written.
+func (s *Source) handle(ctx, inFlight context.Context, msg kafka.Message) bool {
+	record, err := s.record(msg)
+	if err != nil {
+		s.config.onError(fmt.Errorf("message at offset %d of %q skipped, %w", msg.Offset, msg.Topic, err))
+
+		s.commit(inFlight, msg)
+
+		return true
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := s.write(inFlight, record)
+		if err == nil {
+			break
+		}
+
+		s.config.onError(err)
+
+		if !s.wait(ctx, attempt+1) {
+			return false
+		}
+	}
+
+	s.commit(inFlight, msg)
+
+	return true
+}
+
+func (s *Source) record(msg kafka.Message) (*inbox.Record, error) {
+	id, err := s.config.id(msg)
+	if err != nil {
+		return nil, fmt.Errorf("event id not extracted, %w", err)
+	}
+
+	eventType, err := s.config.eventType(msg)
+	if err != nil {
+		return nil, fmt.Errorf("event type not extracted, %w", err)
+	}
+
+	return inbox.NewRecord(id, eventType, msg.Value, msg.Time)
+}
+
+func (s *Source) write(ctx context.Context, record *inbox.Record) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.writeTimeout)
+	defer cancel()
+
+	if err := s.writer.WriteInbox(ctx, record); err != nil {
+		return fmt.Errorf("event not written to inbox, %w", err)
+	}
+
+	return nil
+}
+
+func (s *Source) commit(ctx context.Context, msg kafka.Message) {
+	if err := s.reader.CommitMessages(ctx, msg); err != nil {
+		s.config.onError(fmt.Errorf("offset %d of %q not committed, %w", msg.Offset, msg.Topic, err))
+	}
+}
+
+// wait waits for the backoff delay of the attempt. Returns false if
+// the context is done before.
+func (s *Source) wait(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(s.backoff.Next(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,215 @@
+package kafkasource_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/kafkasource"
+)
+
+// fakeReader is in-process fake of the consumer group reader.
+type fakeReader struct {
+	messages chan kafka.Message
+
+	mu        sync.Mutex
+	committed []int64
+}
+
+func newFakeReader(msgs ...kafka.Message) *fakeReader {
+	r := &fakeReader{
+		messages: make(chan kafka.Message, len(msgs)),
+	}
+
+	for _, msg := range msgs {
+		r.messages <- msg
+	}
+
+	return r
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg := <-r.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, msg := range msgs {
+		r.committed = append(r.committed, msg.Offset)
+	}
+
+	return nil
+}
+
+func (r *fakeReader) Committed() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]int64(nil), r.committed...)
+}
+
+// fakeWriter fails first failures writes.
+type fakeWriter struct {
+	mu       sync.Mutex
+	failures int
+	records  []*inbox.Record
+}
+
+func (w *fakeWriter) WriteInbox(_ context.Context, record *inbox.Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failures > 0 {
+		w.failures--
+
+		return errors.New("db is down")
+	}
+
+	w.records = append(w.records, record)
+
+	return nil
+}
+
+func (w *fakeWriter) Records() []*inbox.Record {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]*inbox.Record(nil), w.records...)
+}
+
+var eventDate = time.Date(2024, 6, 5, 17, 55, 2, 0, time.UTC)
+
+func message(offset int64, id string) kafka.Message {
+	return kafka.Message{
+		Topic:  "orders",
+		Offset: offset,
+		Key:    []byte(id),
+		Value:  []byte("{}"),
+		Headers: []kafka.Header{
+			{Key: kafkasource.HeaderEventType, Value: []byte("order.created")},
+		},
+		Time: eventDate,
+	}
+}
+
+func start(t *testing.T, reader kafkasource.Reader, writer inbox.Client, opts ...kafkasource.Option) {
+	t.Helper()
+
+	opts = append(opts, kafkasource.WithRetryBackoff(time.Millisecond, 10*time.Millisecond))
+
+	source := kafkasource.New(reader, writer, opts...)
+
+	err := source.Start(context.Background())
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = source.Shutdown(context.Background())
+	})
+}
+
+func TestSource_Should_commit_offset_after_writing_to_inbox(t *testing.T) {
+	var (
+		id     = uuid.New()
+		reader = newFakeReader(message(1, id.String()))
+		writer = &fakeWriter{}
+	)
+
+	start(t, reader, writer)
+
+	assert.Eventually(t, func() bool {
+		return len(reader.Committed()) == 1
+	}, time.Second, time.Millisecond)
+
+	expected, _ := inbox.NewRecord(id, "order.created", []byte("{}"), eventDate)
+	assert.Equal(t, []*inbox.Record{expected}, writer.Records())
+	assert.Equal(t, []int64{1}, reader.Committed())
+}
+
+func TestSource_Should_retry_writing_before_commit(t *testing.T) {
+	var (
+		reader = newFakeReader(message(1, uuid.NewString()), message(2, uuid.NewString()))
+		writer = &fakeWriter{failures: 3}
+		errs   = make(chan error, 10)
+	)
+
+	start(t, reader, writer, kafkasource.OnErrorCallback(func(err error) {
+		errs <- err
+	}))
+
+	assert.Eventually(t, func() bool {
+		return len(reader.Committed()) == 2
+	}, time.Second, time.Millisecond)
+
+	assert.Len(t, writer.Records(), 2)
+	assert.Equal(t, []int64{1, 2}, reader.Committed())
+	assert.Len(t, errs, 3)
+}
+
+func TestSource_Should_skip_message_with_incorrect_id(t *testing.T) {
+	var (
+		reader = newFakeReader(message(1, "not-uuid"), message(2, uuid.NewString()))
+		writer = &fakeWriter{}
+	)
+
+	start(t, reader, writer)
+
+	assert.Eventually(t, func() bool {
+		return len(reader.Committed()) == 2
+	}, time.Second, time.Millisecond)
+
+	assert.Len(t, writer.Records(), 1)
+}
+
+func TestSource_Should_take_id_from_header(t *testing.T) {
+	var (
+		id     = uuid.New()
+		msg    = message(1, "order-1")
+		writer = &fakeWriter{}
+	)
+
+	msg.Headers = append(msg.Headers, kafka.Header{Key: kafkasource.HeaderEventID, Value: []byte(id.String())})
+
+	reader := newFakeReader(msg)
+
+	start(t, reader, writer)
+
+	assert.Eventually(t, func() bool {
+		return len(reader.Committed()) == 1
+	}, time.Second, time.Millisecond)
+
+	expected, _ := inbox.NewRecord(id, "order.created", []byte("{}"), eventDate)
+	assert.Equal(t, []*inbox.Record{expected}, writer.Records())
+}
+
+func TestSource_Shutdown_Should_not_commit_not_written_message(t *testing.T) {
+	var (
+		reader = newFakeReader(message(1, uuid.NewString()))
+		writer = &fakeWriter{failures: 1000}
+		source = kafkasource.New(reader, writer)
+	)
+
+	err := source.Start(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = source.Shutdown(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, reader.Committed())
+}
@@ -0,0 +1,156 @@
+package inbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ProcessFunc processes the payload of the event, the same as
+// Handler.Process.
+type ProcessFunc func(ctx context.Context, payload []byte) error
+
+// Middleware wraps the Process of the handler the way net/http middleware
+// does. It can run code before and after the next function, replace its
+// error or not call it at all. Use EventFromContext to get the event that
+// is processed.
+type Middleware func(next ProcessFunc) ProcessFunc
+
+// Event describes the event which is processed by the handler.
+type Event struct {
+	ID         uuid.UUID
+	EventType  string
+	HandlerKey string
+	// Attempt is the number of failed attempts before the current one.
+	Attempt int
+}
+
+type eventKey struct{}
+
+// EventFromContext returns the event which is processed by the handler
+// with the context.
+func EventFromContext(ctx context.Context) (Event, bool) {
+	event, ok := ctx.Value(eventKey{}).(Event)
+
+	return event, ok
+}
+
+func withEvent(ctx context.Context, record *Record) context.Context {
+	return context.WithValue(ctx, eventKey{}, Event{
+		ID:         record.id,
+		EventType:  record.eventType,
+		HandlerKey: record.handlerKey,
+		Attempt:    record.attempt.attempt,
+	})
+}
+
+// wrappedHandler is the Handler which Process is wrapped with middlewares.
+type wrappedHandler struct {
+	Handler
+	process ProcessFunc
+}
+
+func (h *wrappedHandler) Process(ctx context.Context, payload []byte) error {
+	return h.process(ctx, payload)
+}
+
+// Wrap returns the handler with the same key which Process is wrapped with
+// the middlewares. The first middleware is the outermost one. Use it to set
+// middlewares of the single registration.
+//
+//	registry.On("orders", inbox.Wrap(handler, inbox.Recover()))
+func Wrap(handler Handler, middlewares ...Middleware) Handler {
+	if len(middlewares) == 0 {
+		return handler
+	}
+
+	process := handler.Process
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		process = middlewares[i](process)
+	}
+
+	return &wrappedHandler{
+		Handler: handler,
+		process: process,
+	}
+}
+
+// Recover returns the middleware which turns the panic of the handler
+// into the failed attempt instead of crashing the worker.
+func Recover() Middleware {
+	return func(next ProcessFunc) ProcessFunc {
+		return func(ctx context.Context, payload []byte) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("handler panics, %v\n%s", r, debug.Stack())
+				}
+			}()
+
+			return next(ctx, payload)
+		}
+	}
+}
+
+// Logging returns the middleware which logs the result of each processed
+// event with the logger. Failed attempts are logged with error level,
+// processed events with debug level. If logger is nil, slog.Default
+// is used.
+func Logging(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(next ProcessFunc) ProcessFunc {
+		return func(ctx context.Context, payload []byte) error {
+			start := time.Now()
+
+			err := next(ctx, payload)
+
+			attrs := []slog.Attr{slog.Duration("duration", time.Since(start))}
+
+			if event, ok := EventFromContext(ctx); ok {
+				attrs = append(attrs,
+					slog.String("event_id", event.ID.String()),
+					slog.String("event_type", event.EventType),
+					slog.String("handler_key", event.HandlerKey),
+					slog.Int("attempt", event.Attempt),
+				)
+			}
+
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+
+				logger.LogAttrs(ctx, slog.LevelError, "inbox event is not processed", attrs...)
+
+				return err
+			}
+
+			logger.LogAttrs(ctx, slog.LevelDebug, "inbox event is processed", attrs...)
+
+			return nil
+		}
+	}
+}
+
+// Timing returns the middleware which calls observe with the duration and
+// the error of each processed event. Use it to export metrics of handlers.
+func Timing(observe func(event Event, dur time.Duration, err error)) Middleware {
+	return func(next ProcessFunc) ProcessFunc {
+		return func(ctx context.Context, payload []byte) error {
+			start := time.Now()
+
+			err := next(ctx, payload)
+
+			event, _ := EventFromContext(ctx)
+
+			observe(event, time.Since(start), err)
+
+			return err
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package inbox_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/storagetest"
+)
+
+type funcHandler struct {
+	key     string
+	process inbox.ProcessFunc
+}
+
+func (h *funcHandler) Key() string {
+	return h.key
+}
+
+func (h *funcHandler) Process(ctx context.Context, payload []byte) error {
+	return h.process(ctx, payload)
+}
+
+func trace(calls *[]string, name string) inbox.Middleware {
+	return func(next inbox.ProcessFunc) inbox.ProcessFunc {
+		return func(ctx context.Context, payload []byte) error {
+			*calls = append(*calls, name+" before")
+
+			err := next(ctx, payload)
+
+			*calls = append(*calls, name+" after")
+
+			return err
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	var (
+		calls   []string
+		handler = &funcHandler{key: "1", process: func(context.Context, []byte) error {
+			calls = append(calls, "handler")
+
+			return nil
+		}}
+	)
+
+	wrapped := inbox.Wrap(handler, trace(&calls, "first"), trace(&calls, "second"))
+
+	assert.Equal(t, "1", wrapped.Key())
+
+	err := wrapped.Process(context.Background(), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"first before", "second before", "handler", "second after", "first after"}, calls)
+}
+
+func TestRegistry_Use(t *testing.T) {
+	var (
+		calls    []string
+		registry = inbox.NewRegistry()
+		handler  = &funcHandler{key: "1", process: func(context.Context, []byte) error {
+			calls = append(calls, "handler")
+
+			return nil
+		}}
+	)
+
+	registry.On("1", inbox.Wrap(handler, trace(&calls, "handler")))
+	registry.Use(trace(&calls, "registry"))
+
+	handlers := registry.Handlers()["1"]
+	require.Len(t, handlers, 1)
+
+	err := handlers[0].Process(context.Background(), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"registry before", "handler before", "handler", "handler after", "registry after"}, calls)
+}
+
+func TestRecover(t *testing.T) {
+	process := inbox.Recover()(func(context.Context, []byte) error {
+		panic("boom")
+	})
+
+	err := process(context.Background(), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "handler panics, boom")
+}
+
+func TestLogging(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	)
+
+	process := inbox.Logging(logger)(func(context.Context, []byte) error {
+		return errors.New("err")
+	})
+
+	err := process(context.Background(), nil)
+	assert.EqualError(t, err, "err")
+
+	assert.Contains(t, buf.String(), "level=ERROR")
+	assert.Contains(t, buf.String(), "error=err")
+}
+
+func TestTiming(t *testing.T) {
+	var observed time.Duration
+
+	process := inbox.Timing(func(_ inbox.Event, dur time.Duration, _ error) {
+		observed = dur
+	})(func(context.Context, []byte) error {
+		time.Sleep(10 * time.Millisecond)
+
+		return nil
+	})
+
+	err := process(context.Background(), nil)
+	require.NoError(t, err)
+
+	assert.GreaterOrEqual(t, observed, 10*time.Millisecond)
+}
+
+func TestInbox_Middleware(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		registry = inbox.NewRegistry()
+		storage  = storagetest.NewMemoryStorage()
+		events   []inbox.Event
+	)
+
+	registry.On("1", &funcHandler{key: "1", process: func(context.Context, []byte) error {
+		panic("boom")
+	}})
+	registry.Use(
+		inbox.Timing(func(event inbox.Event, _ time.Duration, _ error) {
+			events = append(events, event)
+		}),
+		inbox.Recover(),
+	)
+
+	svc := inbox.NewInbox(registry, nil, inbox.WithStorage(storage))
+
+	record, err := inbox.NewRecord(inbox.ID1(), "1", []byte("{}"))
+	require.NoError(t, err)
+
+	err = svc.Writer().WriteInbox(ctx, record)
+	require.NoError(t, err)
+
+	err = svc.Iteration()
+	require.NoError(t, err)
+
+	assert.Equal(t, []inbox.Event{{ID: inbox.ID1(), EventType: "1", HandlerKey: "1"}}, events)
+
+	// The panic is counted as failed attempt.
+	records, err := storage.Fetch(ctx, time.Now().UTC().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, 1, records[0].Attempt())
+	assert.Contains(t, records[0].ErrorMessage(), "handler panics, boom")
+}
@@ -1,3 +1 @@
-drop index if exists __inbox_uniq_id_handler_key_idx;
-
-drop table if exists __inbox_table;
+drop table if exists {{.Table}};
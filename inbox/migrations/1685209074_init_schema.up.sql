@@ -1,4 +1,4 @@
-create table if not exists __inbox_table
+create table if not exists {{.Table}}
 (
 	id varchar(36) not null,
 	status varchar(12),
@@ -9,4 +9,4 @@ create table if not exists __inbox_table
 	updated_at timestamp not null default (now() at time zone 'utc')
 );
 
-create unique index if not exists __inbox_uniq_id_handler_key_idx on __inbox_table (id, handler_key);
+create unique index if not exists {{.Table.Index "uniq_id_handler_key_idx"}} on {{.Table}} (id, handler_key);
@@ -1,4 +1,4 @@
-	alter table if exists __inbox_table
+	alter table if exists {{.Table}}
 		add column attempt smallint not null default 0,
 	 	add column error_message text,
 	 	add column next_attempt timestamp;
@@ -0,0 +1,14 @@
+create table if not exists {{.Table}} (
+    id varchar(36) not null,
+    handler_key varchar(255) not null,
+    status varchar(12),
+    event_type varchar(255) not null,
+    payload longblob not null,
+    attempt smallint not null default 0,
+    error_message text,
+    next_attempt datetime(6),
+    created_at datetime(6) not null default (utc_timestamp(6)),
+    updated_at datetime(6) not null default (utc_timestamp(6)),
+    primary key (id, handler_key),
+    key {{.Table.Index "status_idx"}} (status, next_attempt)
+);
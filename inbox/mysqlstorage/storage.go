@@ -0,0 +1,297 @@
+// Package mysqlstorage implements inbox.Storage on top of MySQL 8 or
+// MariaDB 10.6 and newer. Both support `for update skip locked`, so
+// several workers can fetch records from the same table concurrently.
+//
+// The connection must be opened with parseTime=true parameter and
+// the default UTC location.
+//
+//	db, _ := sql.Open("mysql", "user:pass@tcp(localhost:3306)/app?parseTime=true")
+//
+//	ib := inbox.NewInbox(registry, nil, inbox.WithStorage(mysqlstorage.New(db)))
+package mysqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/mysqlstorage/migrations"
+	"github.com/Melenium2/go-iobox/migration"
+)
+
+var _ inbox.Storage = (*Storage)(nil)
+
+// defaultMigrationsTable is the migrations table of the inbox
+// table with default name.
+const defaultMigrationsTable = "inbox_schema"
+
+// Storage stores inbox records in the MySQL table.
+type Storage struct {
+	conn  *sql.DB
+	table migration.Table
+	// tableName is quoted name of the table.
+	tableName    string
+	batchSize    int
+	leaseTimeout time.Duration
+}
+
+// New creates new Storage. The table is created by InitInboxTable.
+func New(conn *sql.DB, opts ...Option) *Storage {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	table := migration.Table{
+		Name:    cfg.tableName,
+		Dialect: migration.MySQL,
+	}
+
+	return &Storage{
+		conn:         conn,
+		table:        table,
+		tableName:    table.String(),
+		batchSize:    cfg.batchSize,
+		leaseTimeout: cfg.leaseTimeout,
+	}
+}
+
+func (s *Storage) InitInboxTable(ctx context.Context) error {
+	m := migration.New()
+
+	fsys := migration.TemplateFS(migrations.FS, s.table)
+
+	if err := m.SetupMySQLFS(ctx, s.conn, fsys, s.migrationsTable()); err != nil {
+		return fmt.Errorf("failed to setup inbox migrations, %w", err)
+	}
+
+	err := m.Up()
+	if err == nil {
+		return nil
+	}
+
+	_ = m.Down()
+
+	return fmt.Errorf("failed to run migrations, %w", err)
+}
+
+// migrationsTable returns the name of the table with migrations
+// version of the inbox table.
+func (s *Storage) migrationsTable() string {
+	if s.table.Name == inbox.DefaultTableName {
+		return defaultMigrationsTable
+	}
+
+	return s.table.Name + "_schema"
+}
+
+// Fetch claims records in the transaction. MySQL does not support
+// `returning`, so keys of the records are locked first, then the records
+// are updated and selected by keys.
+func (s *Storage) Fetch(ctx context.Context, fetchTime time.Time) ([]*inbox.Record, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	keys, err := s.claim(ctx, tx, fetchTime)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	if len(keys) == 0 {
+		return nil, inbox.ErrNoRecords
+	}
+
+	// Assignments are evaluated from left to right, so the attempt
+	// is incremented before the status is changed.
+	sqlStr := "update " + s.tableName + " set " +
+		" 			attempt = if(status = 'progress', attempt + 1, attempt), " +
+		" 			status = ?, " +
+		" 			updated_at = utc_timestamp(6) " +
+		" 		where (id, handler_key) in " + keyPlaceholders(len(keys)/2) + ";"
+
+	args := append([]any{string(inbox.Progress)}, keys...)
+
+	if _, err = tx.ExecContext(ctx, sqlStr, args...); err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	records, err := s.selectRecords(ctx, tx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	return records, nil
+}
+
+// claim locks the oldest records which are ready to be processed or which
+// lease is expired and returns their ids and handler keys one by one. Rows
+// locked by another worker are skipped.
+func (s *Storage) claim(ctx context.Context, tx *sql.Tx, fetchTime time.Time) ([]any, error) {
+	sqlStr := "select id, handler_key from " + s.tableName +
+		" 		where " +
+		" 			status is null or " +
+		" 			(status = 'failed' and next_attempt <= ?) or " +
+		" 			(status = 'progress' and updated_at <= ?) " +
+		" 		order by created_at " +
+		" 		limit ? " +
+		" 		for update skip locked;"
+
+	leaseDeadline := fetchTime.Add(-s.leaseTimeout)
+
+	rows, err := tx.QueryContext(ctx, sqlStr, fetchTime, leaseDeadline, s.batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := make([]any, 0)
+
+	for rows.Next() {
+		var id, handlerKey string
+
+		if err = rows.Scan(&id, &handlerKey); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, id, handlerKey)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *Storage) selectRecords(ctx context.Context, tx *sql.Tx, keys []any) ([]*inbox.Record, error) {
+	sqlStr := "select id, status, event_type, handler_key, payload, attempt, created_at " +
+		" 		from " + s.tableName +
+		" 		where (id, handler_key) in " + keyPlaceholders(len(keys)/2) +
+		" 		order by created_at;"
+
+	rows, err := tx.QueryContext(ctx, sqlStr, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	records := make([]*inbox.Record, 0, len(keys)/2)
+
+	for rows.Next() {
+		var (
+			state  inbox.RecordState
+			id     string
+			status sql.NullString
+		)
+
+		err = rows.Scan(&id, &status, &state.EventType, &state.HandlerKey, &state.Payload, &state.Attempt, &state.EventDate)
+		if err != nil {
+			return nil, err
+		}
+
+		if state.ID, err = uuid.Parse(id); err != nil {
+			return nil, err
+		}
+
+		state.Status = inbox.Status(status.String)
+		state.EventDate = state.EventDate.UTC()
+
+		records = append(records, inbox.RestoreRecord(state))
+	}
+
+	return records, rows.Err()
+}
+
+func (s *Storage) Update(ctx context.Context, records []*inbox.Record) error {
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = ?, " +
+		" 			attempt = ?, " +
+		" 			error_message = ?, " +
+		" 			next_attempt = ?, " +
+		" 			updated_at = utc_timestamp(6) " +
+		" 		where id = ? and handler_key = ?;"
+
+	for _, curr := range records {
+		var (
+			recordStatus    sql.NullString
+			errorMessage    sql.NullString
+			attemptDeadline sql.NullTime
+		)
+
+		if curr.Status() != inbox.Null {
+			recordStatus = sql.NullString{String: string(curr.Status()), Valid: true}
+		}
+
+		if curr.ErrorMessage() != "" {
+			errorMessage = sql.NullString{String: curr.ErrorMessage(), Valid: true}
+		}
+
+		if !curr.NextAttempt().IsZero() {
+			attemptDeadline = sql.NullTime{Time: curr.NextAttempt(), Valid: true}
+		}
+
+		_, err := s.conn.ExecContext(
+			ctx,
+			sqlStr,
+			recordStatus,
+			curr.Attempt(),
+			errorMessage,
+			attemptDeadline,
+			curr.ID().String(),
+			curr.HandlerKey(),
+		)
+		if err != nil {
+			return fmt.Errorf("error while updating records, %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) Insert(ctx context.Context, record *inbox.Record) error {
+	sqlStr := "insert into " + s.tableName + " (id, event_type, handler_key, payload, created_at) " +
+		" values (?, ?, ?, ?, ?) on duplicate key update id = id;"
+
+	_, err := s.conn.ExecContext(
+		ctx,
+		sqlStr,
+		record.ID().String(),
+		record.EventType(),
+		record.HandlerKey(),
+		record.Payload(),
+		record.EventDate().UTC(),
+	)
+
+	return err
+}
+
+func (s *Storage) Purge(ctx context.Context, before time.Time) (int64, error) {
+	sqlStr := "delete from " + s.tableName + " where date(created_at) < date(?);"
+
+	result, err := s.conn.ExecContext(ctx, sqlStr, before)
+	if err != nil {
+		return 0, fmt.Errorf("error while purging records, %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// keyPlaceholders returns the list of n placeholders of
+// (id, handler_key) pairs in parentheses.
+func keyPlaceholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("(?, ?), ", n), ", ") + ")"
+}
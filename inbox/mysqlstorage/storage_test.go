@@ -0,0 +1,78 @@
+package mysqlstorage_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/mysqlstorage"
+	"github.com/Melenium2/go-iobox/inbox/storagetest"
+)
+
+type StorageSuite struct {
+	suite.Suite
+
+	db      *sql.DB
+	storage *mysqlstorage.Storage
+}
+
+func TestStorageSuite(t *testing.T) {
+	suite.Run(t, &StorageSuite{})
+}
+
+func (suite *StorageSuite) SetupSuite() {
+	db, err := sql.Open("mysql", "root:mysql@tcp(localhost:3307)/outbox?parseTime=true")
+	suite.Require().NoError(err)
+
+	err = db.Ping()
+	suite.Require().NoError(err)
+
+	suite.db = db
+	suite.storage = mysqlstorage.New(db)
+
+	err = suite.storage.InitInboxTable(context.Background())
+	suite.Require().NoError(err)
+}
+
+func (suite *StorageSuite) TestStorage_Should_pass_conformance_tests() {
+	storagetest.Run(suite.T(), func(*testing.T) inbox.Storage {
+		_, err := suite.db.Exec("delete from __inbox_table;")
+		suite.Require().NoError(err)
+
+		return suite.storage
+	})
+}
+
+func (suite *StorageSuite) TestInitInboxTable_Should_create_table_with_custom_name() {
+	ctx := context.Background()
+
+	storage := mysqlstorage.New(suite.db, mysqlstorage.WithTableName("orders_inbox"))
+
+	err := storage.InitInboxTable(ctx)
+	suite.Require().NoError(err)
+
+	defer func() {
+		_, _ = suite.db.Exec("drop table if exists orders_inbox, orders_inbox_schema;")
+	}()
+
+	record := inbox.RestoreRecord(inbox.RecordState{
+		ID:         uuid.New(),
+		EventType:  "topic1",
+		HandlerKey: "handler1",
+		Payload:    []byte("{}"),
+		EventDate:  time.Now().UTC(),
+	})
+
+	err = storage.Insert(ctx, record)
+	suite.Require().NoError(err)
+
+	records, err := storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().NoError(err)
+	suite.Assert().Len(records, 1)
+}
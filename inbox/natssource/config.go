@@ -0,0 +1,158 @@
+package natssource
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/Melenium2/go-iobox/backoff"
+)
+
+const (
+	// DefaultWriteTimeout is the timeout after which writing of the
+	// message to the inbox table is canceled.
+	DefaultWriteTimeout = 5 * time.Second
+	// DefaultRetryMin is the min delay before the failed message
+	// is redelivered.
+	DefaultRetryMin = time.Second
+	// DefaultRetryMax is the max delay before the failed message
+	// is redelivered.
+	DefaultRetryMax = 30 * time.Second
+	// HeaderEventType is the header with the event type of the message.
+	HeaderEventType = "Event-Type"
+)
+
+type (
+	// IDExtractor returns the event ID of the message.
+	IDExtractor func(jetstream.Msg) (uuid.UUID, error)
+	// EventTypeExtractor returns the event type of the message.
+	EventTypeExtractor func(jetstream.Msg) (string, error)
+	// ErrorCallback prototype of function that is called if errors occurs
+	// during consuming.
+	ErrorCallback func(err error)
+)
+
+func nopErrorCallback(error) {}
+
+// IDFromMsgID parses the event ID from the Nats-Msg-Id header.
+func IDFromMsgID(msg jetstream.Msg) (uuid.UUID, error) {
+	return IDFromHeader(jetstream.MsgIDHeader)(msg)
+}
+
+// IDFromHeader parses the event ID from the header.
+func IDFromHeader(header string) IDExtractor {
+	return func(msg jetstream.Msg) (uuid.UUID, error) {
+		value := msg.Headers().Get(header)
+		if value == "" {
+			return uuid.Nil, fmt.Errorf("header %q not found", header)
+		}
+
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("incorrect event id in header %q, %w", header, err)
+		}
+
+		return id, nil
+	}
+}
+
+// EventTypeFromSubject uses the subject as the event type.
+func EventTypeFromSubject(msg jetstream.Msg) (string, error) {
+	return msg.Subject(), nil
+}
+
+// EventTypeFromHeader takes the event type from the header.
+func EventTypeFromHeader(header string) EventTypeExtractor {
+	return func(msg jetstream.Msg) (string, error) {
+		value := msg.Headers().Get(header)
+		if value == "" {
+			return "", fmt.Errorf("header %q not found", header)
+		}
+
+		return value, nil
+	}
+}
+
+type config struct {
+	writeTimeout time.Duration
+	retry        backoff.Config
+	id           IDExtractor
+	eventType    EventTypeExtractor
+	onError      ErrorCallback
+}
+
+func defaultConfig() config {
+	return config{
+		writeTimeout: DefaultWriteTimeout,
+		retry: backoff.Config{
+			Min: DefaultRetryMin,
+			Max: DefaultRetryMax,
+		},
+		id:        IDFromMsgID,
+		eventType: EventTypeFromHeader(HeaderEventType),
+		onError:   nopErrorCallback,
+	}
+}
+
+// Option sets specific configuration to the Source.
+type Option func(config) config
+
+// WithWriteTimeout sets the timeout of writing the message to
+// the inbox table.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c config) config {
+		c.writeTimeout = timeout
+
+		return c
+	}
+}
+
+// WithRetryBackoff sets the min and max delay before the failed message
+// is redelivered. The delay grows with the number of deliveries.
+func WithRetryBackoff(minDelay, maxDelay time.Duration) Option {
+	return func(c config) config {
+		c.retry = backoff.Config{
+			Min: minDelay,
+			Max: maxDelay,
+		}
+
+		return c
+	}
+}
+
+// WithIDExtractor sets the function that returns the event ID of the
+// message. By default, the ID is parsed from the Nats-Msg-Id header.
+func WithIDExtractor(extractor IDExtractor) Option {
+	return func(c config) config {
+		if extractor != nil {
+			c.id = extractor
+		}
+
+		return c
+	}
+}
+
+// WithEventTypeExtractor sets the function that returns the event type
+// of the message. By default, the event type is taken from the
+// HeaderEventType header.
+func WithEventTypeExtractor(extractor EventTypeExtractor) Option {
+	return func(c config) config {
+		if extractor != nil {
+			c.eventType = extractor
+		}
+
+		return c
+	}
+}
+
+// OnErrorCallback sets the callback which is called if error
+// occurs during consuming.
+func OnErrorCallback(callback ErrorCallback) Option {
+	return func(c config) config {
+		c.onError = callback
+
+		return c
+	}
+}
@@ -0,0 +1,209 @@
+// Package natssource consumes events from NATS JetStream pull consumer
+// and writes them to the inbox table.
+//
+// The message is acknowledged only after it is written to the inbox
+// table. If writing fails, the message is redelivered by the server
+// after the retry delay.
+package natssource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/Melenium2/go-iobox/backoff"
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/internal/lifecycle"
+)
+
+// Consumer returns messages of the pull consumer. jetstream.Consumer
+// implements the interface.
+type Consumer interface {
+	Messages(opts ...jetstream.PullMessagesOpt) (jetstream.MessagesContext, error)
+}
+
+// Source reads messages from JetStream and writes them to the inbox table.
+type Source struct {
+	consumer Consumer
+	writer   inbox.Client
+	config   config
+
+	backoff *backoff.Backoff
+
+	lifecycle *lifecycle.Lifecycle
+}
+
+// New creates new Source reading messages of the consumer. Use
+// inbox.Inbox.Writer as the writer.
+func New(consumer Consumer, writer inbox.Client, opts ...Option) *Source {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return &Source{
+		consumer:  consumer,
+		writer:    writer,
+		config:    cfg,
+		backoff:   backoff.NewBackoff(cfg.retry),
+		lifecycle: lifecycle.New(),
+	}
+}
+
+// Start starts reading messages. Closing the context stops reading
+// without waiting for in-flight messages, use Shutdown to stop the
+// source gracefully.
+func (s *Source) Start(ctx context.Context) error {
+	messages, err := s.consumer.Messages()
+	if err != nil {
+		return fmt.Errorf("can not read messages of consumer, %w", err)
+	}
+
+	// In-flight messages are written with context that is not
+	// canceled with ctx, so they can be finished during shutdown.
+	inFlight := s.lifecycle.InFlight(ctx)
+
+	// done is closed when reading is stopped.
+	done := make(chan struct{})
+
+	s.lifecycle.Go(func() {
+		s.run(inFlight, done, messages)
+	})
+
+	s.lifecycle.Go(func() {
+		// Stopping the iterator unblocks waiting for the next message.
+		select {
+		case <-ctx.Done():
+		case <-s.lifecycle.Stopped():
+		}
+
+		close(done)
+		messages.Stop()
+	})
+
+	return nil
+}
+
+// Shutdown stops reading and waits for the in-flight message until
+// the context is done. The message that is not acknowledged is
+// redelivered by the server after the ack wait.
+func (s *Source) Shutdown(ctx context.Context) error {
+	return s.lifecycle.Shutdown(ctx)
+}
+
+func (s *Source) run(ctx context.Context, done <-chan struct{}, messages jetstream.MessagesContext) {
+	var failures int
+
+	for {
+		msg, err := messages.Next()
+		if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+			return
+		}
+
+		if err != nil {
+			s.config.onError(fmt.Errorf("message not received, %w", err))
+
+			// The error can last, e.g. if the consumer is deleted, so
+			// reading is retried with the backoff.
+			failures++
+
+			if !wait(done, s.backoff.Next(failures)) {
+				return
+			}
+
+			continue
+		}
+
+		failures = 0
+
+		s.handle(ctx, msg)
+	}
+}
+
+// wait waits for the delay. Returns false if done is closed before.
+func wait(done <-chan struct{}, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// handle writes the message to the inbox table and acknowledges it.
+// Message that can not be mapped to the inbox record is terminated,
+// because it never succeeds.
+func (s *Source) handle(ctx context.Context, msg jetstream.Msg) {
+	record, err := s.record(msg)
+	if err != nil {
+		s.config.onError(err)
+
+		if err := msg.TermWithReason(err.Error()); err != nil {
+			s.config.onError(fmt.Errorf("message not terminated, %w", err))
+		}
+
+		return
+	}
+
+	if err := s.write(ctx, record); err != nil {
+		s.config.onError(err)
+
+		if err := msg.NakWithDelay(s.backoff.Next(s.attempt(msg))); err != nil {
+			s.config.onError(fmt.Errorf("message not nacked, %w", err))
+		}
+
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		s.config.onError(fmt.Errorf("message not acked, %w", err))
+	}
+}
+
+func (s *Source) record(msg jetstream.Msg) (*inbox.Record, error) {
+	id, err := s.config.id(msg)
+	if err != nil {
+		return nil, fmt.Errorf("event id not extracted, %w", err)
+	}
+
+	eventType, err := s.config.eventType(msg)
+	if err != nil {
+		return nil, fmt.Errorf("event type not extracted, %w", err)
+	}
+
+	var eventDate time.Time
+
+	if meta, err := msg.Metadata(); err == nil {
+		eventDate = meta.Timestamp.UTC()
+	}
+
+	return inbox.NewRecord(id, eventType, msg.Data(), eventDate)
+}
+
+func (s *Source) write(ctx context.Context, record *inbox.Record) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.writeTimeout)
+	defer cancel()
+
+	if err := s.writer.WriteInbox(ctx, record); err != nil {
+		return fmt.Errorf("event not written to inbox, %w", err)
+	}
+
+	return nil
+}
+
+// attempt returns the number of deliveries of the message.
+func (s *Source) attempt(msg jetstream.Msg) int {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return 0
+	}
+
+	return int(meta.NumDelivered)
+}
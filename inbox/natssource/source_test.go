@@ -0,0 +1,250 @@
+package natssource_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/natssource"
+)
+
+// fakeWriter fails first failures writes.
+type fakeWriter struct {
+	mu       sync.Mutex
+	failures int
+	records  []*inbox.Record
+}
+
+func (w *fakeWriter) WriteInbox(_ context.Context, record *inbox.Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failures > 0 {
+		w.failures--
+
+		return errors.New("db is down")
+	}
+
+	w.records = append(w.records, record)
+
+	return nil
+}
+
+func (w *fakeWriter) Records() []*inbox.Record {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]*inbox.Record(nil), w.records...)
+}
+
+// runJetStream starts embedded server and creates the orders stream
+// with the pull consumer.
+func runJetStream(t *testing.T) (jetstream.JetStream, jetstream.Consumer) {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+
+	go srv.Start()
+
+	require.True(t, srv.ReadyForConnections(5*time.Second))
+
+	conn, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Shutdown()
+	})
+
+	ctx := context.Background()
+
+	js, err := jetstream.New(conn)
+	require.NoError(t, err)
+
+	stream, err := js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:     "ORDERS",
+		Subjects: []string{"orders.>"},
+	})
+	require.NoError(t, err)
+
+	consumer, err := stream.CreateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:   "inbox",
+		AckPolicy: jetstream.AckExplicitPolicy,
+	})
+	require.NoError(t, err)
+
+	return js, consumer
+}
+
+func publish(t *testing.T, js jetstream.JetStream, id string, eventType string) {
+	t.Helper()
+
+	msg := &nats.Msg{
+		Subject: "orders.created",
+		Header:  nats.Header{},
+		Data:    []byte("{}"),
+	}
+
+	msg.Header.Set(jetstream.MsgIDHeader, id)
+	msg.Header.Set(natssource.HeaderEventType, eventType)
+
+	_, err := js.PublishMsg(context.Background(), msg)
+	require.NoError(t, err)
+}
+
+func start(t *testing.T, consumer jetstream.Consumer, writer inbox.Client, opts ...natssource.Option) {
+	t.Helper()
+
+	opts = append(opts, natssource.WithRetryBackoff(time.Millisecond, 10*time.Millisecond))
+
+	source := natssource.New(consumer, writer, opts...)
+
+	err := source.Start(context.Background())
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = source.Shutdown(context.Background())
+	})
+}
+
+// acked reports whether all messages of the consumer are acknowledged.
+func acked(t *testing.T, consumer jetstream.Consumer, count uint64) bool {
+	info, err := consumer.Info(context.Background())
+	require.NoError(t, err)
+
+	return info.AckFloor.Stream == count && info.NumAckPending == 0
+}
+
+func TestSource_Should_ack_message_after_writing_to_inbox(t *testing.T) {
+	var (
+		js, consumer = runJetStream(t)
+		writer       = &fakeWriter{}
+		id           = uuid.New()
+	)
+
+	publish(t, js, id.String(), "order.created")
+
+	start(t, consumer, writer)
+
+	assert.Eventually(t, func() bool {
+		return acked(t, consumer, 1)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stream, err := js.Stream(context.Background(), "ORDERS")
+	require.NoError(t, err)
+
+	stored, err := stream.GetMsg(context.Background(), 1)
+	require.NoError(t, err)
+
+	expected, _ := inbox.NewRecord(id, "order.created", []byte("{}"), stored.Time.UTC())
+	assert.Equal(t, []*inbox.Record{expected}, writer.Records())
+}
+
+func TestSource_Should_redeliver_message_if_writing_failed(t *testing.T) {
+	var (
+		js, consumer = runJetStream(t)
+		writer       = &fakeWriter{failures: 2}
+	)
+
+	publish(t, js, uuid.NewString(), "order.created")
+
+	start(t, consumer, writer)
+
+	assert.Eventually(t, func() bool {
+		return acked(t, consumer, 1)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Len(t, writer.Records(), 1)
+}
+
+func TestSource_Should_terminate_message_with_incorrect_id(t *testing.T) {
+	var (
+		js, consumer = runJetStream(t)
+		writer       = &fakeWriter{}
+	)
+
+	publish(t, js, "not-uuid", "order.created")
+	publish(t, js, uuid.NewString(), "order.created")
+
+	start(t, consumer, writer, natssource.WithEventTypeExtractor(natssource.EventTypeFromSubject))
+
+	assert.Eventually(t, func() bool {
+		return acked(t, consumer, 2)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Len(t, writer.Records(), 1)
+}
+
+// brokenConsumer returns the iterator which fails until it is stopped.
+type brokenConsumer struct{}
+
+func (brokenConsumer) Messages(...jetstream.PullMessagesOpt) (jetstream.MessagesContext, error) {
+	return &brokenIterator{stopped: make(chan struct{})}, nil
+}
+
+type brokenIterator struct {
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+func (it *brokenIterator) Next() (jetstream.Msg, error) {
+	select {
+	case <-it.stopped:
+		return nil, jetstream.ErrMsgIteratorClosed
+	default:
+		return nil, jetstream.ErrConsumerDeleted
+	}
+}
+
+func (it *brokenIterator) Stop() {
+	it.stopOnce.Do(func() {
+		close(it.stopped)
+	})
+}
+
+func (it *brokenIterator) Drain() {
+	it.Stop()
+}
+
+func TestSource_Should_retry_reading_with_backoff(t *testing.T) {
+	var errs atomic.Int64
+
+	source := natssource.New(
+		brokenConsumer{},
+		&fakeWriter{},
+		natssource.WithRetryBackoff(10*time.Millisecond, 20*time.Millisecond),
+		natssource.OnErrorCallback(func(error) {
+			errs.Add(1)
+		}),
+	)
+
+	err := source.Start(context.Background())
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	err = source.Shutdown(context.Background())
+	require.NoError(t, err)
+
+	assert.Positive(t, errs.Load())
+	assert.LessOrEqual(t, errs.Load(), int64(15))
+}
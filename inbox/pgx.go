@@ -0,0 +1,12 @@
+package inbox
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// NewInboxFromPool creates new inbox implementation which uses
+// connections of the pgx pool.
+func NewInboxFromPool(registry *Registry, pool *pgxpool.Pool, opts ...Option) *Inbox {
+	return NewInbox(registry, stdlib.OpenDBFromPool(pool), opts...)
+}
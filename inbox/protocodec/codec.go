@@ -0,0 +1,64 @@
+// Package protocodec implements inbox.Codec of protobuf messages.
+//
+//	registry.On("order.created", inbox.NewTypedHandler("create_order", protocodec.Codec{},
+//		func(ctx context.Context, order *orderpb.Order) error {
+//			return svc.Create(ctx, order)
+//		},
+//	))
+package protocodec
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Melenium2/go-iobox/inbox"
+)
+
+var _ inbox.Codec = Codec{}
+
+// Codec decodes payloads in the protobuf wire format. The value must
+// be a message or a pointer to the message pointer, which is allocated
+// if it is nil.
+type Codec struct {
+	// Options of the unmarshaling, e.g. DiscardUnknown.
+	Options proto.UnmarshalOptions
+}
+
+func (c Codec) Unmarshal(data []byte, v any) error {
+	msg, err := message(v)
+	if err != nil {
+		return err
+	}
+
+	return c.Options.Unmarshal(data, msg)
+}
+
+// message returns the message to decode into from v.
+func message(v any) (proto.Message, error) {
+	if msg, ok := v.(proto.Message); ok {
+		return msg, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return nil, fmt.Errorf("%T is not a protobuf message", v)
+	}
+
+	elem := rv.Elem()
+	if elem.Kind() != reflect.Pointer {
+		return nil, fmt.Errorf("%T is not a protobuf message", v)
+	}
+
+	if elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+
+	msg, ok := elem.Interface().(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protobuf message", v)
+	}
+
+	return msg, nil
+}
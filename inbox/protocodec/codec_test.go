@@ -0,0 +1,66 @@
+package protocodec_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/protocodec"
+)
+
+func TestCodec_Unmarshal(t *testing.T) {
+	payload, err := proto.Marshal(wrapperspb.String("order"))
+	require.NoError(t, err)
+
+	t.Run("should decode into message", func(t *testing.T) {
+		msg := &wrapperspb.StringValue{}
+
+		err := protocodec.Codec{}.Unmarshal(payload, msg)
+		require.NoError(t, err)
+		assert.Equal(t, "order", msg.GetValue())
+	})
+
+	t.Run("should allocate nil message", func(t *testing.T) {
+		var msg *wrapperspb.StringValue
+
+		err := protocodec.Codec{}.Unmarshal(payload, &msg)
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, "order", msg.GetValue())
+	})
+
+	t.Run("should return error if value is not message", func(t *testing.T) {
+		var msg string
+
+		err := protocodec.Codec{}.Unmarshal(payload, &msg)
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error if payload is invalid", func(t *testing.T) {
+		err := protocodec.Codec{}.Unmarshal([]byte{0xff}, &wrapperspb.StringValue{})
+		assert.Error(t, err)
+	})
+}
+
+func TestTypedHandler(t *testing.T) {
+	payload, err := proto.Marshal(wrapperspb.String("order"))
+	require.NoError(t, err)
+
+	var received string
+
+	handler := inbox.NewTypedHandler("1", protocodec.Codec{}, func(_ context.Context, msg *wrapperspb.StringValue) error {
+		received = msg.GetValue()
+
+		return nil
+	})
+
+	err = handler.Process(context.Background(), payload)
+	require.NoError(t, err)
+
+	assert.Equal(t, "order", received)
+}
@@ -22,6 +22,9 @@ const (
 	Null Status = ""
 	// Dead means the current Record is not processable.
 	Dead Status = "dead"
+	// Skipped means the current Record is intentionally ignored by
+	// the handler.
+	Skipped Status = "skipped"
 )
 
 type attempt struct {
@@ -69,6 +72,37 @@ func NewRecord(id uuid.UUID, eventType string, payload []byte, eventDate ...time
 	}, nil
 }
 
+// RecordState is the stored state of the Record. Storage implementations
+// use it to restore fetched records with RestoreRecord.
+type RecordState struct {
+	ID           uuid.UUID
+	EventType    string
+	HandlerKey   string
+	Status       Status
+	Payload      []byte
+	Attempt      int
+	ErrorMessage string
+	NextAttempt  time.Time
+	EventDate    time.Time
+}
+
+// RestoreRecord creates the Record from the stored state.
+func RestoreRecord(state RecordState) *Record {
+	return &Record{
+		id:         state.ID,
+		eventType:  state.EventType,
+		handlerKey: state.HandlerKey,
+		status:     state.Status,
+		payload:    state.Payload,
+		attempt: attempt{
+			attempt:     state.Attempt,
+			message:     state.ErrorMessage,
+			nextAttempt: state.NextAttempt,
+		},
+		eventDate: state.EventDate,
+	}
+}
+
 func newFullRecord(
 	id uuid.UUID,
 	status Status,
@@ -106,8 +140,20 @@ func (r *Record) Fail(err error) {
 	r.attempt.attempt++
 }
 
+// Dead sets Dead status to current Record. The record will not be
+// processed again, so the next attempt is reset.
 func (r *Record) Dead() {
 	r.status = Dead
+	r.attempt.nextAttempt = time.Time{}
+}
+
+// Skip sets Skipped status to current Record and saves the reason
+// as the error message. The record will not be processed again.
+func (r *Record) Skip(reason error) {
+	r.status = Skipped
+
+	r.attempt.message = reason.Error()
+	r.attempt.nextAttempt = time.Time{}
 }
 
 // Null sets Null status to current Record.
@@ -115,10 +161,51 @@ func (r *Record) Null() {
 	r.status = ""
 }
 
+// ID returns the unique id of current Record.
+func (r *Record) ID() uuid.UUID {
+	return r.id
+}
+
+// EventType returns the event type of current Record.
+func (r *Record) EventType() string {
+	return r.eventType
+}
+
+// HandlerKey returns the key of the handler which processes current Record.
+func (r *Record) HandlerKey() string {
+	return r.handlerKey
+}
+
+// Status returns the status of current Record.
+func (r *Record) Status() Status {
+	return r.status
+}
+
+// Payload returns the received body of current Record.
+func (r *Record) Payload() []byte {
+	return r.payload
+}
+
+// Attempt returns the number of failed attempts to process current Record.
 func (r *Record) Attempt() int {
 	return r.attempt.attempt
 }
 
+// ErrorMessage returns the error of the last failed attempt.
+func (r *Record) ErrorMessage() string {
+	return r.attempt.message
+}
+
+// NextAttempt returns the time after which failed Record is processed again.
+func (r *Record) NextAttempt() time.Time {
+	return r.attempt.nextAttempt
+}
+
+// EventDate returns the time when the event was occurred.
+func (r *Record) EventDate() time.Time {
+	return r.eventDate
+}
+
 func (r *Record) CalcNewDeadline(dur time.Duration) {
 	now := time.Now().UTC()
 	now = now.Add(dur)
@@ -17,7 +17,8 @@ type Handler interface {
 
 // Registry contains all handler that will be processed by Inbox.
 type Registry struct {
-	eventMap *eventMap
+	eventMap    *eventMap
+	middlewares []Middleware
 }
 
 func NewRegistry() *Registry {
@@ -80,8 +81,29 @@ func (r *Registry) On(event string, handlers ...Handler) {
 	r.eventMap.Set(event, correctHandlers...)
 }
 
+// Use adds middlewares to all handlers of the Registry. Middlewares
+// are applied to handlers registered before and after the call, but
+// they must be added before the Inbox is created. Middlewares of the
+// Registry wrap the middlewares set with Wrap.
+func (r *Registry) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
 // Handlers returns map where key is event type and values are handlers
-// associated to this event type.
+// associated to this event type. Handlers are wrapped with middlewares
+// of the Registry.
 func (r *Registry) Handlers() map[string][]Handler {
-	return r.eventMap.Copy()
+	subjects := r.eventMap.Copy()
+
+	if len(r.middlewares) == 0 {
+		return subjects
+	}
+
+	for _, handlers := range subjects {
+		for i, handler := range handlers {
+			handlers[i] = Wrap(handler, r.middlewares...)
+		}
+	}
+
+	return subjects
 }
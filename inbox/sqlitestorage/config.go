@@ -0,0 +1,58 @@
+package sqlitestorage
+
+import (
+	"time"
+
+	"github.com/Melenium2/go-iobox/inbox"
+)
+
+type config struct {
+	tableName    string
+	batchSize    int
+	leaseTimeout time.Duration
+}
+
+func defaultConfig() config {
+	return config{
+		tableName:    inbox.DefaultTableName,
+		batchSize:    inbox.DefaultBatchSize,
+		leaseTimeout: inbox.DefaultLeaseTimeout,
+	}
+}
+
+// Option sets specific configuration to the Storage.
+type Option func(config) config
+
+// WithTableName sets custom name of the inbox table. Migrations of the
+// table are tracked in the separate '<name>_schema' table.
+func WithTableName(name string) Option {
+	return func(c config) config {
+		c.tableName = name
+
+		return c
+	}
+}
+
+// WithBatchSize sets the max number of records fetched from the inbox
+// table in one batch.
+func WithBatchSize(size int) Option {
+	return func(c config) config {
+		if size > 0 {
+			c.batchSize = size
+		}
+
+		return c
+	}
+}
+
+// WithLeaseTimeout sets the time after which records stuck in 'progress'
+// status are fetched again.
+func WithLeaseTimeout(dur time.Duration) Option {
+	return func(c config) config {
+		if dur > 0 {
+			c.leaseTimeout = dur
+		}
+
+		return c
+	}
+}
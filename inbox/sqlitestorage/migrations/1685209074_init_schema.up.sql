@@ -0,0 +1,15 @@
+create table if not exists {{.Table}} (
+    id text not null,
+    handler_key text not null,
+    status text,
+    event_type text not null,
+    payload blob not null,
+    attempt integer not null default 0,
+    error_message text,
+    next_attempt text,
+    created_at text not null,
+    updated_at text not null,
+    primary key (id, handler_key)
+);
+
+create index if not exists {{.Table.Index "status_idx"}} on {{.Table}} (status, next_attempt);
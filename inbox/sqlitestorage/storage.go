@@ -0,0 +1,302 @@
+// Package sqlitestorage implements inbox.Storage on top of SQLite with
+// the pure Go modernc.org/sqlite driver, so it does not require cgo or
+// a database server.
+//
+// SQLite allows only one writer at the same time. Each write of the
+// Storage is a single statement, so it acquires the write lock at once
+// and waits for other writers with the busy timeout of the connection.
+// Writes of the Storage are also serialized inside the process.
+//
+//	db, _ := sql.Open("sqlite", "file:agent.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+//
+//	ib := inbox.NewInbox(registry, nil, inbox.WithStorage(sqlitestorage.New(db)))
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/google/uuid"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/sqlitestorage/migrations"
+	"github.com/Melenium2/go-iobox/migration"
+)
+
+var _ inbox.Storage = (*Storage)(nil)
+
+// defaultMigrationsTable is the migrations table of the inbox
+// table with default name.
+const defaultMigrationsTable = "inbox_schema"
+
+// timeFormat is the format of the time columns. SQLite does not have
+// the time type, so the time is stored as text of the same length in
+// UTC, which is compared in the chronological order.
+const timeFormat = "2006-01-02 15:04:05.000000"
+
+// Storage stores inbox records in the SQLite table.
+type Storage struct {
+	conn  *sql.DB
+	table migration.Table
+	// tableName is quoted name of the table.
+	tableName    string
+	batchSize    int
+	leaseTimeout time.Duration
+
+	// mu serializes writes of the Storage, SQLite allows
+	// only one writer.
+	mu sync.Mutex
+}
+
+// New creates new Storage. The table is created by InitInboxTable.
+func New(conn *sql.DB, opts ...Option) *Storage {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	table := migration.Table{
+		Name:    cfg.tableName,
+		Dialect: migration.SQLite,
+	}
+
+	return &Storage{
+		conn:         conn,
+		table:        table,
+		tableName:    table.String(),
+		batchSize:    cfg.batchSize,
+		leaseTimeout: cfg.leaseTimeout,
+	}
+}
+
+func (s *Storage) InitInboxTable(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	driver, err := sqlite.WithInstance(s.conn, &sqlite.Config{MigrationsTable: s.migrationsTable()})
+	if err != nil {
+		return fmt.Errorf("failed to setup inbox migrations, %w", err)
+	}
+
+	m := migration.New()
+
+	fsys := migration.TemplateFS(migrations.FS, s.table)
+
+	if err = m.SetupDriverFS(fsys, "sqlite", driver); err != nil {
+		return fmt.Errorf("failed to setup inbox migrations, %w", err)
+	}
+
+	err = m.Up()
+	if err == nil {
+		return nil
+	}
+
+	_ = m.Down()
+
+	return fmt.Errorf("failed to run migrations, %w", err)
+}
+
+// migrationsTable returns the name of the table with migrations
+// version of the inbox table.
+func (s *Storage) migrationsTable() string {
+	if s.table.Name == inbox.DefaultTableName {
+		return defaultMigrationsTable
+	}
+
+	return s.table.Name + "_schema"
+}
+
+// Fetch claims the oldest records and the records which lease is expired
+// with a single statement, so the records are claimed atomically under
+// the write lock.
+func (s *Storage) Fetch(ctx context.Context, fetchTime time.Time) ([]*inbox.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = ?, " +
+		" 			attempt = case when status = 'progress' then attempt + 1 else attempt end, " +
+		" 			updated_at = ? " +
+		" 		where rowid in ( " +
+		" 			select rowid from " + s.tableName +
+		" 			where " +
+		" 				status is null or " +
+		" 				(status = 'failed' and next_attempt <= ?) or " +
+		" 				(status = 'progress' and updated_at <= ?) " +
+		" 			order by created_at " +
+		" 			limit ? " +
+		" 		) " +
+		" 		returning id, status, event_type, handler_key, payload, attempt, created_at;"
+
+	var (
+		now           = formatTime(time.Now())
+		leaseDeadline = formatTime(fetchTime.Add(-s.leaseTimeout))
+	)
+
+	rows, err := s.conn.QueryContext(
+		ctx, sqlStr, string(inbox.Progress), now, formatTime(fetchTime), leaseDeadline, s.batchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	records, err := scanRecords(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	if len(records) == 0 {
+		return nil, inbox.ErrNoRecords
+	}
+
+	return records, nil
+}
+
+// scanRecords scans the rows to the records sorted by event date.
+func scanRecords(rows *sql.Rows) ([]*inbox.Record, error) {
+	defer rows.Close()
+
+	states := make([]inbox.RecordState, 0)
+
+	for rows.Next() {
+		var (
+			state     inbox.RecordState
+			id        string
+			status    sql.NullString
+			createdAt string
+		)
+
+		err := rows.Scan(&id, &status, &state.EventType, &state.HandlerKey, &state.Payload, &state.Attempt, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if state.ID, err = uuid.Parse(id); err != nil {
+			return nil, err
+		}
+
+		if state.EventDate, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+
+		state.Status = inbox.Status(status.String)
+
+		states = append(states, state)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The order of rows returned by `returning` is not defined.
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].EventDate.Before(states[j].EventDate)
+	})
+
+	records := make([]*inbox.Record, 0, len(states))
+
+	for _, state := range states {
+		records = append(records, inbox.RestoreRecord(state))
+	}
+
+	return records, nil
+}
+
+func (s *Storage) Update(ctx context.Context, records []*inbox.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = ?, " +
+		" 			attempt = ?, " +
+		" 			error_message = ?, " +
+		" 			next_attempt = ?, " +
+		" 			updated_at = ? " +
+		" 		where id = ? and handler_key = ?;"
+
+	for _, curr := range records {
+		var (
+			recordStatus    sql.NullString
+			errorMessage    sql.NullString
+			attemptDeadline sql.NullString
+		)
+
+		if curr.Status() != inbox.Null {
+			recordStatus = sql.NullString{String: string(curr.Status()), Valid: true}
+		}
+
+		if curr.ErrorMessage() != "" {
+			errorMessage = sql.NullString{String: curr.ErrorMessage(), Valid: true}
+		}
+
+		if !curr.NextAttempt().IsZero() {
+			attemptDeadline = sql.NullString{String: formatTime(curr.NextAttempt()), Valid: true}
+		}
+
+		_, err := s.conn.ExecContext(
+			ctx,
+			sqlStr,
+			recordStatus,
+			curr.Attempt(),
+			errorMessage,
+			attemptDeadline,
+			formatTime(time.Now()),
+			curr.ID().String(),
+			curr.HandlerKey(),
+		)
+		if err != nil {
+			return fmt.Errorf("error while updating records, %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) Insert(ctx context.Context, record *inbox.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sqlStr := "insert into " + s.tableName + " (id, event_type, handler_key, payload, created_at, updated_at) " +
+		" values (?, ?, ?, ?, ?, ?) on conflict do nothing;"
+
+	_, err := s.conn.ExecContext(
+		ctx,
+		sqlStr,
+		record.ID().String(),
+		record.EventType(),
+		record.HandlerKey(),
+		record.Payload(),
+		formatTime(record.EventDate()),
+		formatTime(time.Now()),
+	)
+
+	return err
+}
+
+func (s *Storage) Purge(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sqlStr := "delete from " + s.tableName + " where date(created_at) < date(?);"
+
+	result, err := s.conn.ExecContext(ctx, sqlStr, formatTime(before))
+	if err != nil {
+		return 0, fmt.Errorf("error while purging records, %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.ParseInLocation(timeFormat, s, time.UTC)
+}
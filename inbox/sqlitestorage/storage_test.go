@@ -0,0 +1,85 @@
+package sqlitestorage_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/sqlitestorage"
+	"github.com/Melenium2/go-iobox/inbox/storagetest"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "inbox.db") +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+	db, err := sql.Open("sqlite", dsn)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+func newStorage(t *testing.T, db *sql.DB, opts ...sqlitestorage.Option) *sqlitestorage.Storage {
+	t.Helper()
+
+	storage := sqlitestorage.New(db, opts...)
+
+	err := storage.InitInboxTable(context.Background())
+	require.NoError(t, err)
+
+	return storage
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) inbox.Storage {
+		return newStorage(t, openDB(t))
+	})
+}
+
+func TestStorage_InitInboxTable(t *testing.T) {
+	t.Run("should create table with custom name", func(t *testing.T) {
+		var (
+			ctx     = context.Background()
+			db      = openDB(t)
+			storage = newStorage(t, db, sqlitestorage.WithTableName("orders_inbox"))
+		)
+
+		record := inbox.RestoreRecord(inbox.RecordState{
+			ID:         uuid.New(),
+			EventType:  "topic1",
+			HandlerKey: "handler1",
+			Payload:    []byte("{}"),
+			EventDate:  time.Now().UTC(),
+		})
+
+		err := storage.Insert(ctx, record)
+		require.NoError(t, err)
+
+		var count int
+
+		err = db.QueryRow("select count(*) from orders_inbox;").Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("should not fail if table is already created", func(t *testing.T) {
+		db := openDB(t)
+
+		newStorage(t, db)
+		newStorage(t, db)
+	})
+}
@@ -6,26 +6,91 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/Melenium2/go-iobox/inbox/migrations"
 	"github.com/Melenium2/go-iobox/migration"
 )
 
-const tableName = "__inbox_table"
+// Storage stores inbox records. The default implementation stores
+// records in the Postgres table, use WithStorage to replace it. Run
+// storagetest.Run to check that the implementation meets the contract.
+type Storage interface {
+	// InitInboxTable prepares the storage, e.g. runs migrations.
+	InitInboxTable(ctx context.Context) error
+	// Fetch claims the next batch of not processed records and failed
+	// records which next attempt is before fetchTime and sets Progress
+	// status to them. The oldest records by event date are claimed first.
+	// Claimed records are not returned by the next calls until their
+	// status is updated or the lease is expired. The attempt of the record
+	// with expired lease is incremented. Records are sorted by event date.
+	// Returns ErrNoRecords if there are no records to process.
+	Fetch(ctx context.Context, fetchTime time.Time) ([]*Record, error)
+	// Update saves statuses and failed attempts of the records. Update
+	// of the record in Progress status renews its lease.
+	Update(ctx context.Context, records []*Record) error
+	// Insert writes the record. Records with existing id and
+	// handler key are ignored.
+	Insert(ctx context.Context, record *Record) error
+	// Purge deletes records created before the date and returns
+	// the number of deleted records.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// NewPostgresStorage creates the default Storage which stores records in
+// the Postgres table. Only options of the table and fetching are applied.
+func NewPostgresStorage(conn *sql.DB, opts ...Option) Storage {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return newStorage(conn, cfg)
+}
+
+// defaultMigrationsTable is the migrations table of the inbox
+// table with default name.
+const defaultMigrationsTable = "inbox_schema"
 
 type defaultStorage struct {
-	conn *sql.DB
+	conn  *sql.DB
+	table migration.Table
+	// tableName is quoted and schema qualified name of the table.
+	tableName    string
+	batchSize    int
+	leaseTimeout time.Duration
 }
 
-func newStorage(conn *sql.DB) *defaultStorage {
+func newStorage(conn *sql.DB, cfg config) *defaultStorage {
+	table := migration.Table{
+		Schema: cfg.schema,
+		Name:   cfg.tableName,
+	}
+
 	return &defaultStorage{
-		conn: conn,
+		conn:         conn,
+		table:        table,
+		tableName:    table.String(),
+		batchSize:    cfg.batchSize,
+		leaseTimeout: cfg.leaseTimeout,
 	}
 }
 
 func (s *defaultStorage) InitInboxTable(ctx context.Context) error {
-	m := migration.New()
+	if s.table.Schema != "" {
+		sqlStr := "create schema if not exists " + pq.QuoteIdentifier(s.table.Schema) + ";"
+
+		if _, err := s.conn.ExecContext(ctx, sqlStr); err != nil {
+			return fmt.Errorf("failed to create inbox schema, %w", err)
+		}
+	}
+
+	m := migration.New(migration.WithSchema(s.table.Schema))
 
-	if err := m.SetupFS(ctx, s.conn, migrations.FS, "inbox_schema"); err != nil {
+	fsys := migration.TemplateFS(migrations.FS, s.table)
+
+	if err := m.SetupFS(ctx, s.conn, fsys, s.migrationsTable()); err != nil {
 		return fmt.Errorf("failed to setup inbox migrations, %w", err)
 	}
 
@@ -39,18 +104,44 @@ func (s *defaultStorage) InitInboxTable(ctx context.Context) error {
 	return fmt.Errorf("failed to run migrations, %w", err)
 }
 
+// migrationsTable returns the name of the table with migrations
+// version of the inbox table.
+func (s *defaultStorage) migrationsTable() string {
+	if s.table.Name == DefaultTableName {
+		return defaultMigrationsTable
+	}
+
+	return s.table.Name + "_schema"
+}
+
+// Fetch claims the next batch of unprocessed records and failed records
+// which next attempt time has come. Records that stay in 'progress' status
+// longer than the lease timeout are claimed again with incremented attempt.
+// Rows locked by another worker are skipped, so several workers can fetch
+// records from the same table concurrently.
 func (s *defaultStorage) Fetch(ctx context.Context, fetchTime time.Time) ([]*Record, error) {
-	dest := make([]*dtoRecord, 0)
+	dest := make([]*dtoRecord, 0, s.batchSize)
 
-	sqlStr := "update " + tableName + " set " +
+	sqlStr := "update " + s.tableName + " set " +
 		" 				status = $1," +
+		" 				attempt = case when status = 'progress' then attempt + 1 else attempt end, " +
 		" 				updated_at = (now() at time zone 'utc') " +
-		" 		where " +
-		" 			status is null or " +
-		" 			(status = 'failed' and next_attempt <= $2) " +
+		" 		where (id, handler_key) in ( " +
+		" 			select t.id, t.handler_key from " + s.tableName + " t " +
+		" 			where " +
+		" 				t.status is null or " +
+		" 				(t.status = 'failed' and t.next_attempt <= $2) or " +
+		" 				(t.status = 'progress' and t.updated_at <= $3) " +
+		" 			order by t.created_at " +
+		" 			limit $4 " +
+		" 			for update skip locked " +
+		" 		) " +
 		" 		returning id, status, event_type, handler_key, payload, attempt, created_at;"
 
-	if err := s.selectRows(ctx, s.conn, &dest, sqlStr, Progress, fetchTime); err != nil {
+	leaseDeadline := fetchTime.Add(-s.leaseTimeout)
+
+	err := s.selectRows(ctx, s.conn, &dest, sqlStr, Progress, fetchTime, leaseDeadline, s.batchSize)
+	if err != nil {
 		return nil, fmt.Errorf("error while fetching records, %w", err)
 	}
 
@@ -66,7 +157,7 @@ func (s *defaultStorage) Update(ctx context.Context, records []*Record) error {
 		return nil
 	}
 
-	sqlStr := "update " + tableName + " set " +
+	sqlStr := "update " + s.tableName + " set " +
 		" 			status = $1, " +
 		" 			attempt = $2, " +
 		" 			error_message = $3, " +
@@ -114,7 +205,7 @@ func (s *defaultStorage) Update(ctx context.Context, records []*Record) error {
 }
 
 func (s *defaultStorage) Insert(ctx context.Context, record *Record) error {
-	sqlStr := "insert into " + tableName + " (id, event_type, handler_key, payload, created_at) " +
+	sqlStr := "insert into " + s.tableName + " (id, event_type, handler_key, payload, created_at) " +
 		" values ($1, $2, $3, $4, $5) on conflict (id, handler_key) do nothing;"
 
 	_, err := s.conn.ExecContext(
@@ -130,6 +221,17 @@ func (s *defaultStorage) Insert(ctx context.Context, record *Record) error {
 	return err
 }
 
+func (s *defaultStorage) Purge(ctx context.Context, before time.Time) (int64, error) {
+	sqlStr := "delete from " + s.tableName + " where created_at::date < $1::date;"
+
+	result, err := s.conn.ExecContext(ctx, sqlStr, before)
+	if err != nil {
+		return 0, fmt.Errorf("error while purging records, %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
 func (s *defaultStorage) selectRows(
 	ctx context.Context, conn *sql.DB, dest *[]*dtoRecord, sqlStr string, args ...any,
 ) error {
@@ -13,13 +13,14 @@ import (
 	"github.com/stretchr/testify/suite"
 
 	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/storagetest"
 )
 
 type StorageSuite struct {
 	suite.Suite
 
 	db      *sql.DB
-	storage *inbox.Storage
+	storage *inbox.PostgresStorage
 }
 
 func TestStorageSuite(t *testing.T) {
@@ -167,6 +168,15 @@ func (suite *StorageSuite) TestInsert_Should_not_insert_table_with_same_id_and_h
 	}
 }
 
+func (suite *StorageSuite) TestStorage_Should_pass_conformance_tests() {
+	storagetest.Run(suite.T(), func(*testing.T) inbox.Storage {
+		_, err := suite.db.Exec("delete from __inbox_table;")
+		suite.Require().NoError(err)
+
+		return suite.storage
+	})
+}
+
 func truncateTable(db *sql.DB) {
 	_, _ = db.Exec("delete from __inbox_table where id in ($1, $2, $3)", inbox.ID1(), inbox.ID2(), inbox.ID3())
 }
@@ -0,0 +1,185 @@
+package storagetest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Melenium2/go-iobox/inbox"
+)
+
+var _ inbox.Storage = (*MemoryStorage)(nil)
+
+type memoryRecord struct {
+	state     inbox.RecordState
+	updatedAt time.Time
+}
+
+// MemoryStorage is inbox.Storage which keeps records in memory. It is
+// useful as a test double of the inbox storage.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	records []*memoryRecord
+
+	batchSize    int
+	leaseTimeout time.Duration
+}
+
+// NewMemoryStorage creates new empty MemoryStorage with the default
+// batch size and lease timeout.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		batchSize:    inbox.DefaultBatchSize,
+		leaseTimeout: inbox.DefaultLeaseTimeout,
+	}
+}
+
+func (s *MemoryStorage) InitInboxTable(context.Context) error {
+	return nil
+}
+
+func (s *MemoryStorage) Fetch(_ context.Context, fetchTime time.Time) ([]*inbox.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		leaseDeadline = fetchTime.Add(-s.leaseTimeout)
+		claimed       = make([]*memoryRecord, 0)
+	)
+
+	for _, curr := range s.records {
+		if ready(curr, fetchTime, leaseDeadline) {
+			claimed = append(claimed, curr)
+		}
+	}
+
+	if len(claimed) == 0 {
+		return nil, inbox.ErrNoRecords
+	}
+
+	sort.SliceStable(claimed, func(i, j int) bool {
+		return claimed[i].state.EventDate.Before(claimed[j].state.EventDate)
+	})
+
+	if len(claimed) > s.batchSize {
+		claimed = claimed[:s.batchSize]
+	}
+
+	now := time.Now().UTC()
+	result := make([]*inbox.Record, 0, len(claimed))
+
+	for _, curr := range claimed {
+		if curr.state.Status == inbox.Progress {
+			// The lease is expired.
+			curr.state.Attempt++
+		}
+
+		curr.state.Status = inbox.Progress
+		curr.updatedAt = now
+
+		result = append(result, inbox.RestoreRecord(curr.state))
+	}
+
+	return result, nil
+}
+
+// ready reports whether the record can be processed at fetchTime.
+func ready(curr *memoryRecord, fetchTime, leaseDeadline time.Time) bool {
+	switch curr.state.Status {
+	case inbox.Null:
+		return true
+	case inbox.Failed:
+		return !curr.state.NextAttempt.After(fetchTime)
+	case inbox.Progress:
+		return !curr.updatedAt.After(leaseDeadline)
+	default:
+		return false
+	}
+}
+
+func (s *MemoryStorage) Update(_ context.Context, records []*inbox.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for _, record := range records {
+		if curr := s.find(record); curr != nil {
+			curr.state.Status = record.Status()
+			curr.state.Attempt = record.Attempt()
+			curr.state.ErrorMessage = record.ErrorMessage()
+			curr.state.NextAttempt = record.NextAttempt()
+			curr.updatedAt = now
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) Insert(_ context.Context, record *inbox.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.find(record) != nil {
+		return nil
+	}
+
+	payload := make([]byte, len(record.Payload()))
+	copy(payload, record.Payload())
+
+	s.records = append(s.records, &memoryRecord{
+		state: inbox.RecordState{
+			ID:         record.ID(),
+			EventType:  record.EventType(),
+			HandlerKey: record.HandlerKey(),
+			Payload:    payload,
+			EventDate:  record.EventDate().UTC(),
+		},
+		updatedAt: time.Now().UTC(),
+	})
+
+	return nil
+}
+
+func (s *MemoryStorage) Purge(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		kept    = make([]*memoryRecord, 0, len(s.records))
+		deleted int64
+		day     = truncateDay(before)
+	)
+
+	for _, curr := range s.records {
+		if truncateDay(curr.state.EventDate).Before(day) {
+			deleted++
+
+			continue
+		}
+
+		kept = append(kept, curr)
+	}
+
+	s.records = kept
+
+	return deleted, nil
+}
+
+func (s *MemoryStorage) find(record *inbox.Record) *memoryRecord {
+	for _, curr := range s.records {
+		if curr.state.ID == record.ID() && curr.state.HandlerKey == record.HandlerKey() {
+			return curr
+		}
+	}
+
+	return nil
+}
+
+// truncateDay returns the date of t, the same as Postgres `::date` does.
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,315 @@
+// Package storagetest provides the conformance test suite of
+// inbox.Storage implementations and the in-memory storage.
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) inbox.Storage {
+//			return newEmptyStorage(t)
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/inbox"
+)
+
+// Factory returns empty initialized storage with the default batch
+// size and lease timeout. It is called for each test.
+type Factory func(t *testing.T) inbox.Storage
+
+var body = []byte(`{"a": 1}`)
+
+// Run runs the conformance tests against the storage.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage inbox.Storage)
+	}{
+		{"Fetch_Should_return_error_if_storage_is_empty", testFetchEmpty},
+		{"Fetch_Should_claim_inserted_records", testFetchInserted},
+		{"Fetch_Should_not_return_claimed_records", testFetchClaimed},
+		{"Fetch_Should_return_at_most_batch_size_records", testFetchBatchSize},
+		{"Fetch_Should_return_claimed_records_after_lease", testFetchLease},
+		{"Insert_Should_ignore_duplicates", testInsertDuplicate},
+		{"Insert_Should_write_same_id_with_different_handler_keys", testInsertHandlerKeys},
+		{"Update_Should_not_return_done_dead_and_skipped_records", testUpdateDone},
+		{"Update_Should_return_released_records_again", testUpdateNull},
+		{"Update_Should_return_failed_records_after_next_attempt", testUpdateFailed},
+		{"Update_Should_renew_lease_of_progress_records", testUpdateProgress},
+		{"Purge_Should_delete_records_created_before_date", testPurge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+func newRecord(t *testing.T, id uuid.UUID, handlerKey string, eventDate time.Time) *inbox.Record {
+	t.Helper()
+
+	record, err := inbox.NewRecord(id, "topic1", body, eventDate)
+	require.NoError(t, err)
+
+	return inbox.RestoreRecord(inbox.RecordState{
+		ID:         record.ID(),
+		EventType:  record.EventType(),
+		HandlerKey: handlerKey,
+		Payload:    record.Payload(),
+		EventDate:  record.EventDate(),
+	})
+}
+
+func insert(t *testing.T, storage inbox.Storage, records ...*inbox.Record) {
+	t.Helper()
+
+	for _, record := range records {
+		err := storage.Insert(context.Background(), record)
+		require.NoError(t, err)
+	}
+}
+
+func fetch(t *testing.T, storage inbox.Storage, fetchTime time.Time) []*inbox.Record {
+	t.Helper()
+
+	records, err := storage.Fetch(context.Background(), fetchTime)
+	if errors.Is(err, inbox.ErrNoRecords) {
+		return nil
+	}
+
+	require.NoError(t, err)
+
+	return records
+}
+
+type key struct {
+	id         uuid.UUID
+	handlerKey string
+}
+
+func keys(records []*inbox.Record) []key {
+	result := make([]key, 0, len(records))
+
+	for _, record := range records {
+		result = append(result, key{id: record.ID(), handlerKey: record.HandlerKey()})
+	}
+
+	return result
+}
+
+func testFetchEmpty(t *testing.T, storage inbox.Storage) {
+	_, err := storage.Fetch(context.Background(), now())
+	assert.ErrorIs(t, err, inbox.ErrNoRecords)
+}
+
+func testFetchInserted(t *testing.T, storage inbox.Storage) {
+	var (
+		id1   = uuid.New()
+		id2   = uuid.New()
+		date1 = now().Add(-time.Minute).Truncate(time.Millisecond)
+		date2 = now().Add(-time.Hour).Truncate(time.Millisecond)
+	)
+
+	insert(t, storage, newRecord(t, id1, "handler1", date1), newRecord(t, id2, "handler2", date2))
+
+	records := fetch(t, storage, now())
+	require.Len(t, records, 2)
+
+	// Records are sorted by event date.
+	first, second := records[0], records[1]
+
+	assert.Equal(t, id2, first.ID())
+	assert.Equal(t, "topic1", first.EventType())
+	assert.Equal(t, "handler2", first.HandlerKey())
+	assert.Equal(t, inbox.Progress, first.Status())
+	assert.Equal(t, 0, first.Attempt())
+	assert.JSONEq(t, string(body), string(first.Payload()))
+	assert.WithinDuration(t, date2, first.EventDate(), time.Millisecond)
+
+	assert.Equal(t, id1, second.ID())
+	assert.Equal(t, "handler1", second.HandlerKey())
+	assert.WithinDuration(t, date1, second.EventDate(), time.Millisecond)
+}
+
+func testFetchClaimed(t *testing.T, storage inbox.Storage) {
+	id := uuid.New()
+
+	insert(t, storage, newRecord(t, id, "handler1", now()))
+
+	assert.Equal(t, []key{{id, "handler1"}}, keys(fetch(t, storage, now())))
+	assert.Empty(t, fetch(t, storage, now()))
+}
+
+func testFetchBatchSize(t *testing.T, storage inbox.Storage) {
+	var (
+		date     = now().Add(-time.Hour).Truncate(time.Millisecond)
+		expected = make([]key, 0, inbox.DefaultBatchSize+1)
+	)
+
+	for i := 0; i <= inbox.DefaultBatchSize; i++ {
+		id := uuid.New()
+
+		insert(t, storage, newRecord(t, id, "handler1", date.Add(time.Duration(i)*time.Second)))
+
+		expected = append(expected, key{id, "handler1"})
+	}
+
+	// The oldest records are claimed first.
+	assert.Equal(t, expected[:inbox.DefaultBatchSize], keys(fetch(t, storage, now())))
+	assert.Equal(t, expected[inbox.DefaultBatchSize:], keys(fetch(t, storage, now())))
+	assert.Empty(t, fetch(t, storage, now()))
+}
+
+func testFetchLease(t *testing.T, storage inbox.Storage) {
+	id := uuid.New()
+
+	insert(t, storage, newRecord(t, id, "handler1", now()))
+
+	records := fetch(t, storage, now())
+	require.Len(t, records, 1)
+	assert.Equal(t, 0, records[0].Attempt())
+
+	// The worker died before the status is updated.
+	leaseExpired := now().Add(inbox.DefaultLeaseTimeout + time.Minute)
+
+	records = fetch(t, storage, leaseExpired)
+	require.Len(t, records, 1)
+	assert.Equal(t, id, records[0].ID())
+	assert.Equal(t, inbox.Progress, records[0].Status())
+	assert.Equal(t, 1, records[0].Attempt())
+
+	assert.Empty(t, fetch(t, storage, now()))
+}
+
+func testInsertDuplicate(t *testing.T, storage inbox.Storage) {
+	id := uuid.New()
+
+	insert(t, storage, newRecord(t, id, "handler1", now()), newRecord(t, id, "handler1", now()))
+
+	assert.Equal(t, []key{{id, "handler1"}}, keys(fetch(t, storage, now())))
+}
+
+func testInsertHandlerKeys(t *testing.T, storage inbox.Storage) {
+	var (
+		id   = uuid.New()
+		date = now()
+	)
+
+	insert(t, storage, newRecord(t, id, "handler1", date), newRecord(t, id, "handler2", date.Add(time.Second)))
+
+	assert.Equal(t, []key{{id, "handler1"}, {id, "handler2"}}, keys(fetch(t, storage, now())))
+}
+
+func testUpdateDone(t *testing.T, storage inbox.Storage) {
+	var (
+		id1 = uuid.New()
+		id2 = uuid.New()
+		id3 = uuid.New()
+	)
+
+	insert(
+		t,
+		storage,
+		newRecord(t, id1, "handler1", now()),
+		newRecord(t, id2, "handler1", now()),
+		newRecord(t, id3, "handler1", now()),
+	)
+
+	records := fetch(t, storage, now())
+	require.Len(t, records, 3)
+
+	records[0].Done()
+	records[1].Dead()
+	records[2].Skip(inbox.Skip)
+
+	err := storage.Update(context.Background(), records)
+	require.NoError(t, err)
+
+	assert.Empty(t, fetch(t, storage, now().Add(time.Hour)))
+}
+
+func testUpdateNull(t *testing.T, storage inbox.Storage) {
+	id := uuid.New()
+
+	insert(t, storage, newRecord(t, id, "handler1", now()))
+
+	records := fetch(t, storage, now())
+	require.Len(t, records, 1)
+
+	records[0].Null()
+
+	err := storage.Update(context.Background(), records)
+	require.NoError(t, err)
+
+	assert.Equal(t, []key{{id, "handler1"}}, keys(fetch(t, storage, now())))
+}
+
+func testUpdateFailed(t *testing.T, storage inbox.Storage) {
+	id := uuid.New()
+
+	insert(t, storage, newRecord(t, id, "handler1", now()))
+
+	records := fetch(t, storage, now())
+	require.Len(t, records, 1)
+
+	records[0].Fail(errors.New("handler failed"))
+	records[0].CalcNewDeadline(time.Hour)
+
+	err := storage.Update(context.Background(), records)
+	require.NoError(t, err)
+
+	assert.Empty(t, fetch(t, storage, now()))
+
+	records = fetch(t, storage, now().Add(time.Hour+time.Minute))
+	require.Len(t, records, 1)
+	assert.Equal(t, id, records[0].ID())
+	assert.Equal(t, 1, records[0].Attempt())
+}
+
+func testUpdateProgress(t *testing.T, storage inbox.Storage) {
+	insert(t, storage, newRecord(t, uuid.New(), "handler1", now()))
+
+	records := fetch(t, storage, now())
+	require.Len(t, records, 1)
+
+	claimed := now()
+
+	time.Sleep(50 * time.Millisecond)
+
+	// The record waits for the worker.
+	err := storage.Update(context.Background(), records)
+	require.NoError(t, err)
+
+	// The lease is expired if it is counted from the claim.
+	assert.Empty(t, fetch(t, storage, claimed.Add(inbox.DefaultLeaseTimeout+25*time.Millisecond)))
+}
+
+func testPurge(t *testing.T, storage inbox.Storage) {
+	ctx := context.Background()
+
+	insert(t, storage, newRecord(t, uuid.New(), "handler1", now()))
+
+	deleted, err := storage.Purge(ctx, now().AddDate(0, 0, -1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	deleted, err = storage.Purge(ctx, now().AddDate(0, 0, 2))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = storage.Fetch(ctx, now())
+	assert.ErrorIs(t, err, inbox.ErrNoRecords)
+}
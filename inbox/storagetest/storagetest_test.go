@@ -0,0 +1,14 @@
+package storagetest_test
+
+import (
+	"testing"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(*testing.T) inbox.Storage {
+		return storagetest.NewMemoryStorage()
+	})
+}
@@ -0,0 +1,55 @@
+package inbox
+
+import (
+	"context"
+	"fmt"
+)
+
+// HandlerFunc processes the decoded payload of the event.
+type HandlerFunc[T any] func(ctx context.Context, msg T) error
+
+var _ Handler = (*TypedHandler[struct{}])(nil)
+
+// TypedHandler is the Handler which decodes the payload with the Codec
+// and passes the value to HandlerFunc. The payload that can not be decoded
+// is never processed successfully, so the decode error is Permanent and
+// the record is marked as 'dead' at once.
+//
+//	registry.On("order.created", inbox.NewTypedHandler("create_order", inbox.JSONCodec{},
+//		func(ctx context.Context, order Order) error {
+//			return svc.Create(ctx, order)
+//		},
+//	))
+type TypedHandler[T any] struct {
+	key     string
+	codec   Codec
+	process HandlerFunc[T]
+}
+
+// NewTypedHandler creates new TypedHandler with the key. If codec is nil,
+// JSONCodec is used.
+func NewTypedHandler[T any](key string, codec Codec, process HandlerFunc[T]) *TypedHandler[T] {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+
+	return &TypedHandler[T]{
+		key:     key,
+		codec:   codec,
+		process: process,
+	}
+}
+
+func (h *TypedHandler[T]) Key() string {
+	return h.key
+}
+
+func (h *TypedHandler[T]) Process(ctx context.Context, payload []byte) error {
+	var msg T
+
+	if err := h.codec.Unmarshal(payload, &msg); err != nil {
+		return Permanent(fmt.Errorf("failed to decode payload, %w", err))
+	}
+
+	return h.process(ctx, msg)
+}
@@ -0,0 +1,88 @@
+package inbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/inbox"
+	"github.com/Melenium2/go-iobox/inbox/storagetest"
+)
+
+type order struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+func TestTypedHandler(t *testing.T) {
+	t.Run("should decode payload", func(t *testing.T) {
+		var received order
+
+		handler := inbox.NewTypedHandler("1", nil, func(_ context.Context, msg order) error {
+			received = msg
+
+			return nil
+		})
+
+		assert.Equal(t, "1", handler.Key())
+
+		err := handler.Process(context.Background(), []byte(`{"id": 1, "title": "book"}`))
+		require.NoError(t, err)
+
+		assert.Equal(t, order{ID: 1, Title: "book"}, received)
+	})
+
+	t.Run("should return error of the handler", func(t *testing.T) {
+		handler := inbox.NewTypedHandler("1", inbox.JSONCodec{}, func(context.Context, order) error {
+			return errors.New("err")
+		})
+
+		err := handler.Process(context.Background(), []byte(`{"id": 1}`))
+		assert.EqualError(t, err, "err")
+	})
+}
+
+func TestInbox_TypedHandler_Should_mark_record_as_dead_if_payload_is_invalid(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		registry = inbox.NewRegistry()
+		storage  = storagetest.NewMemoryStorage()
+		dead     int
+		calls    int
+	)
+
+	registry.On("1", inbox.NewTypedHandler("1", inbox.JSONCodec{}, func(context.Context, order) error {
+		calls++
+
+		return nil
+	}))
+
+	svc := inbox.NewInbox(
+		registry,
+		nil,
+		inbox.WithStorage(storage),
+		inbox.OnDeadCallback(func(uuid.UUID, string) {
+			dead++
+		}),
+	)
+
+	record, err := inbox.NewRecord(inbox.ID1(), "1", []byte(`{"id": "not a number"}`))
+	require.NoError(t, err)
+
+	err = svc.Writer().WriteInbox(ctx, record)
+	require.NoError(t, err)
+
+	err = svc.Iteration()
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, 1, dead)
+
+	_, err = storage.Fetch(ctx, time.Now().UTC().Add(time.Hour))
+	assert.ErrorIs(t, err, inbox.ErrNoRecords)
+}
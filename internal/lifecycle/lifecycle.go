@@ -0,0 +1,113 @@
+// Package lifecycle implements graceful shutdown of the background
+// workers of outbox, inbox and event sources.
+package lifecycle
+
+import (
+	"context"
+	"sync"
+)
+
+// Lifecycle tracks background goroutines of the worker. Shutdown stops
+// the worker from taking new work and waits for in-flight work until
+// the deadline, after that in-flight work is canceled.
+type Lifecycle struct {
+	// stopped is closed when shutdown is started. The worker
+	// does not take new work after that.
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	mu sync.Mutex
+	// cancel aborts in-flight work if shutdown deadline is exceeded.
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates new Lifecycle.
+func New() *Lifecycle {
+	return &Lifecycle{
+		stopped: make(chan struct{}),
+		cancel:  func() {},
+	}
+}
+
+// InFlight returns the context of in-flight work. It is not canceled
+// with ctx, so the work can be finished during shutdown, and it is
+// canceled only if shutdown deadline is exceeded.
+func (l *Lifecycle) InFlight(ctx context.Context) context.Context {
+	return l.Context(context.WithoutCancel(ctx))
+}
+
+// Context returns the context which is canceled with ctx and also if
+// shutdown deadline is exceeded, so the work which is not interrupted
+// by shutdown does not delay Shutdown after the deadline.
+func (l *Lifecycle) Context(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+
+	l.mu.Lock()
+	prev := l.cancel
+	l.cancel = func() {
+		prev()
+		cancel()
+	}
+	l.mu.Unlock()
+
+	return ctx
+}
+
+// Go runs fn in the goroutine which is waited by Shutdown.
+func (l *Lifecycle) Go(fn func()) {
+	l.wg.Add(1)
+
+	go func() {
+		defer l.wg.Done()
+
+		fn()
+	}()
+}
+
+// Stopped returns the channel which is closed when shutdown is started.
+func (l *Lifecycle) Stopped() <-chan struct{} {
+	return l.stopped
+}
+
+// IsStopped reports whether shutdown is started.
+func (l *Lifecycle) IsStopped() bool {
+	select {
+	case <-l.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown starts shutdown and waits for the goroutines until the context
+// is done. After that in-flight work is canceled and Shutdown waits for
+// the goroutines to return. Returns the error of the context if the
+// deadline is exceeded.
+func (l *Lifecycle) Shutdown(ctx context.Context) error {
+	l.stopOnce.Do(func() {
+		close(l.stopped)
+	})
+
+	finished := make(chan struct{})
+
+	go func() {
+		l.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		cancel := l.cancel
+		l.mu.Unlock()
+
+		cancel()
+
+		<-finished
+
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,105 @@
+package lifecycle_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/internal/lifecycle"
+)
+
+func TestLifecycle_Shutdown(t *testing.T) {
+	t.Run("should wait for in-flight work", func(t *testing.T) {
+		var (
+			l        = lifecycle.New()
+			inFlight = l.InFlight(context.Background())
+			finished bool
+		)
+
+		l.Go(func() {
+			<-l.Stopped()
+
+			time.Sleep(10 * time.Millisecond)
+
+			finished = inFlight.Err() == nil
+		})
+
+		err := l.Shutdown(context.Background())
+		require.NoError(t, err)
+
+		assert.True(t, finished)
+		assert.True(t, l.IsStopped())
+	})
+
+	t.Run("should cancel in-flight work after deadline", func(t *testing.T) {
+		var (
+			l        = lifecycle.New()
+			inFlight = l.InFlight(context.Background())
+		)
+
+		l.Go(func() {
+			<-inFlight.Done()
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := l.Shutdown(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("should not cancel in-flight work with parent context", func(t *testing.T) {
+		var (
+			l           = lifecycle.New()
+			ctx, cancel = context.WithCancel(context.Background())
+			inFlight    = l.InFlight(ctx)
+		)
+
+		cancel()
+
+		assert.NoError(t, inFlight.Err())
+	})
+
+	t.Run("should cancel work with context after deadline", func(t *testing.T) {
+		var (
+			l           = lifecycle.New()
+			ctx, cancel = context.WithCancel(context.Background())
+			work        = l.Context(ctx)
+		)
+
+		defer cancel()
+
+		l.Go(func() {
+			<-work.Done()
+		})
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer shutdownCancel()
+
+		err := l.Shutdown(shutdownCtx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.NoError(t, ctx.Err())
+	})
+
+	t.Run("should cancel work with parent context", func(t *testing.T) {
+		var (
+			l           = lifecycle.New()
+			ctx, cancel = context.WithCancel(context.Background())
+			work        = l.Context(ctx)
+		)
+
+		cancel()
+
+		assert.Error(t, work.Err())
+	})
+
+	t.Run("should be called several times", func(t *testing.T) {
+		l := lifecycle.New()
+
+		require.NoError(t, l.Shutdown(context.Background()))
+		require.NoError(t, l.Shutdown(context.Background()))
+	})
+}
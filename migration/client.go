@@ -9,18 +9,40 @@ import (
 	"sync"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
 type Client struct {
 	once     sync.Once
 	migrator *migrate.Migrate
+	schema   string
 }
 
-func New() *Client {
-	return &Client{}
+// Option sets specific configuration to the Client.
+type Option func(*Client)
+
+// WithSchema sets the schema in which the migrations table is created.
+// The schema must exist.
+func WithSchema(schema string) Option {
+	return func(c *Client) {
+		c.schema = schema
+	}
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
 func (c *Client) Setup(ctx context.Context, db *sql.DB, path, migrTable string) error {
@@ -50,10 +72,43 @@ func (c *Client) Setup(ctx context.Context, db *sql.DB, path, migrTable string)
 }
 
 func (c *Client) SetupFS(ctx context.Context, db *sql.DB, fs fs.FS, migrTable string) error {
+	return c.setupFS(fs, "postgres", func() (database.Driver, error) {
+		return c.postgres(ctx, db, migrTable)
+	})
+}
+
+// SetupMySQLFS is the same as SetupFS, but runs migrations on the MySQL
+// database. The migrations table is created in the database of the
+// connection, so the schema of the Client is ignored.
+func (c *Client) SetupMySQLFS(ctx context.Context, db *sql.DB, fs fs.FS, migrTable string) error {
+	return c.setupFS(fs, "mysql", func() (database.Driver, error) {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := &mysql.Config{
+			MigrationsTable: migrTable,
+		}
+
+		return mysql.WithConnection(ctx, conn, cfg)
+	})
+}
+
+// SetupDriverFS is the same as SetupFS, but runs migrations with the
+// golang-migrate database driver. Use it for databases which drivers are
+// not imported by the package, e.g. SQLite.
+func (c *Client) SetupDriverFS(fs fs.FS, name string, driver database.Driver) error {
+	return c.setupFS(fs, name, func() (database.Driver, error) {
+		return driver, nil
+	})
+}
+
+func (c *Client) setupFS(fs fs.FS, name string, driver func() (database.Driver, error)) error {
 	var setupErr error
 
 	c.once.Do(func() {
-		post, err := c.postgres(ctx, db, migrTable)
+		instance, err := driver()
 		if err != nil {
 			setupErr = err
 
@@ -67,7 +122,7 @@ func (c *Client) SetupFS(ctx context.Context, db *sql.DB, fs fs.FS, migrTable st
 			return
 		}
 
-		migr, err := migrate.NewWithInstance("iofs", input, "postgres", post)
+		migr, err := migrate.NewWithInstance("iofs", input, name, instance)
 		if err != nil {
 			setupErr = err
 
@@ -80,6 +135,11 @@ func (c *Client) SetupFS(ctx context.Context, db *sql.DB, fs fs.FS, migrTable st
 	return setupErr
 }
 
+// SetupPoolFS is the same as SetupFS, but uses connection of the pgx pool.
+func (c *Client) SetupPoolFS(ctx context.Context, pool *pgxpool.Pool, fs fs.FS, migrTable string) error {
+	return c.SetupFS(ctx, stdlib.OpenDBFromPool(pool), fs, migrTable)
+}
+
 func (c *Client) postgres(ctx context.Context, db *sql.DB, table string) (*postgres.Postgres, error) {
 	conn, err := db.Conn(ctx)
 	if err != nil {
@@ -88,6 +148,7 @@ func (c *Client) postgres(ctx context.Context, db *sql.DB, table string) (*postg
 
 	cfg := &postgres.Config{
 		MigrationsTable: table,
+		SchemaName:      c.schema,
 	}
 
 	post, err := postgres.WithConnection(ctx, conn, cfg)
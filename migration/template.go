@@ -0,0 +1,150 @@
+package migration
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/lib/pq"
+)
+
+// Dialect defines how identifiers are quoted.
+type Dialect int
+
+const (
+	// Postgres quotes identifiers with double quotes. It is the
+	// default dialect.
+	Postgres Dialect = iota
+	// MySQL quotes identifiers with backticks.
+	MySQL
+	// SQLite quotes identifiers with double quotes.
+	SQLite
+)
+
+// Table is the name of the table that is created by migrations.
+type Table struct {
+	// Schema of the table. If empty, the current schema of the
+	// connection is used.
+	Schema string
+	// Name of the table.
+	Name string
+	// Dialect of the database in which the table is created.
+	Dialect Dialect
+}
+
+// String returns quoted and schema qualified table name.
+func (t Table) String() string {
+	if t.Schema == "" {
+		return t.quote(t.Name)
+	}
+
+	return t.quote(t.Schema) + "." + t.quote(t.Name)
+}
+
+// Index returns quoted name of the table index. The name is built
+// from the table name and the suffix, so indexes of different tables
+// do not conflict.
+func (t Table) Index(suffix string) string {
+	return t.quote(t.Name + "_" + suffix)
+}
+
+func (t Table) quote(name string) string {
+	if t.Dialect == MySQL {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+
+	return pq.QuoteIdentifier(name)
+}
+
+// templateData is the data available in migration templates.
+//
+// Example:
+//
+//	create table if not exists {{.Table}} (id varchar(36) not null);
+//	create index if not exists {{.Table.Index "id_idx"}} on {{.Table}} (id);
+type templateData struct {
+	Table Table
+}
+
+// TemplateFS returns fs.FS which executes all *.sql files of fsys as
+// text/template with the provided table. It allows to run the same
+// migrations for tables with different names.
+func TemplateFS(fsys fs.FS, table Table) fs.FS {
+	return &templateFS{
+		fsys: fsys,
+		data: templateData{Table: table},
+	}
+}
+
+type templateFS struct {
+	fsys fs.FS
+	data templateData
+}
+
+func (t *templateFS) Open(name string) (fs.File, error) {
+	f, err := t.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if path.Ext(name) != ".sql" {
+		return f, nil
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(name).Parse(string(raw))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	var buf bytes.Buffer
+
+	if err = tmpl.Execute(&buf, t.data); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &templateFile{
+		Reader: bytes.NewReader(buf.Bytes()),
+		info:   &templateFileInfo{FileInfo: info, size: int64(buf.Len())},
+	}, nil
+}
+
+func (t *templateFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(t.fsys, name)
+}
+
+type templateFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *templateFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *templateFile) Close() error {
+	return nil
+}
+
+type templateFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *templateFileInfo) Size() int64 {
+	return i.size
+}
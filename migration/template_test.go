@@ -0,0 +1,81 @@
+package migration_test
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/migration"
+)
+
+func TestTable_String(t *testing.T) {
+	t.Run("should return quoted table name", func(t *testing.T) {
+		table := migration.Table{Name: "__outbox_table"}
+
+		assert.Equal(t, `"__outbox_table"`, table.String())
+	})
+
+	t.Run("should return quoted and schema qualified table name", func(t *testing.T) {
+		table := migration.Table{Schema: "messaging", Name: "outbox"}
+
+		assert.Equal(t, `"messaging"."outbox"`, table.String())
+	})
+
+	t.Run("should return table name quoted with backticks in mysql dialect", func(t *testing.T) {
+		table := migration.Table{Schema: "messaging", Name: "out`box", Dialect: migration.MySQL}
+
+		assert.Equal(t, "`messaging`.`out``box`", table.String())
+	})
+}
+
+func TestTable_Index(t *testing.T) {
+	t.Run("should return index name with table name prefix", func(t *testing.T) {
+		table := migration.Table{Schema: "messaging", Name: "outbox"}
+
+		assert.Equal(t, `"outbox_id_idx"`, table.Index("id_idx"))
+	})
+}
+
+func TestTemplateFS(t *testing.T) {
+	source := fstest.MapFS{
+		"1_init.up.sql": &fstest.MapFile{
+			Data: []byte(`create index {{.Table.Index "id_idx"}} on {{.Table}} (id);`),
+		},
+		"readme.txt": &fstest.MapFile{
+			Data: []byte(`{{.Table}}`),
+		},
+	}
+
+	fsys := migration.TemplateFS(source, migration.Table{Schema: "messaging", Name: "outbox"})
+
+	t.Run("should execute sql files as templates", func(t *testing.T) {
+		expected := `create index "outbox_id_idx" on "messaging"."outbox" (id);`
+
+		f, err := fsys.Open("1_init.up.sql")
+		require.NoError(t, err)
+
+		raw, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(raw))
+
+		info, err := f.Stat()
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(expected)), info.Size())
+	})
+
+	t.Run("should not change other files", func(t *testing.T) {
+		raw, err := fs.ReadFile(fsys, "readme.txt")
+		require.NoError(t, err)
+		assert.Equal(t, `{{.Table}}`, string(raw))
+	})
+
+	t.Run("should list files of the source", func(t *testing.T) {
+		entries, err := fs.ReadDir(fsys, ".")
+		require.NoError(t, err)
+		assert.Len(t, entries, 2)
+	})
+}
@@ -0,0 +1,224 @@
+// Package amqpbroker implements outbox.Broker and outbox.MessageBroker
+// on top of RabbitMQ.
+//
+// Events are published in confirm mode, so publishing succeeds only
+// after RabbitMQ confirms that the event is accepted. The connection
+// and channel are recovered automatically on the next publishing if
+// they are closed.
+package amqpbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/Melenium2/go-iobox/outbox"
+)
+
+var (
+	// ErrNacked is returned if RabbitMQ does not confirm the event.
+	ErrNacked = errors.New("event is not confirmed by broker")
+	// ErrReturned is returned if the event is mandatory and RabbitMQ
+	// can not route it to any queue.
+	ErrReturned = errors.New("event is returned by broker")
+	// ErrClosed is returned if the broker is closed.
+	ErrClosed = errors.New("broker is closed")
+)
+
+var (
+	_ outbox.Broker        = (*Broker)(nil)
+	_ outbox.MessageBroker = (*Broker)(nil)
+)
+
+// Broker publishes outbox events to RabbitMQ.
+//
+// Publishing is serialized, because the confirmation and the returned
+// event are matched with the last published event.
+type Broker struct {
+	url    string
+	config config
+
+	mu      sync.Mutex
+	closed  bool
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	returns chan amqp.Return
+}
+
+// New creates new Broker and connects to RabbitMQ at the url.
+func New(url string, opts ...Option) (*Broker, error) {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	b := &Broker{
+		url:    url,
+		config: cfg,
+	}
+
+	if _, err := b.connect(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Publish publishes the payload with the event type subject.
+func (b *Broker) Publish(ctx context.Context, subject string, payload []byte) error {
+	return b.PublishMessage(ctx, outbox.Message{
+		EventType: subject,
+		Payload:   payload,
+	})
+}
+
+// PublishMessage publishes the message to the route of its event type
+// and waits for the confirmation from RabbitMQ.
+func (b *Broker) PublishMessage(ctx context.Context, msg outbox.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch, err := b.connect()
+	if err != nil {
+		return err
+	}
+
+	route := b.config.route(msg.EventType)
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		route.Exchange,
+		route.RoutingKey,
+		b.config.mandatory,
+		false,
+		b.publishing(msg),
+	)
+	if err != nil {
+		b.reset()
+
+		return fmt.Errorf("event not published to %q, %w", route.Exchange, err)
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		// The confirmation can be received after the next publishing,
+		// so the channel is reopened to not mix them up.
+		b.reset()
+
+		return fmt.Errorf("event confirmation not received, %w", err)
+	}
+
+	// RabbitMQ returns the event before the confirmation, so the
+	// returned event is already received if any.
+	select {
+	case ret, ok := <-b.returns:
+		if ok {
+			return fmt.Errorf("%w, %d %s", ErrReturned, ret.ReplyCode, ret.ReplyText)
+		}
+	default:
+	}
+
+	if !acked {
+		return ErrNacked
+	}
+
+	return nil
+}
+
+// Close closes the connection to RabbitMQ. The broker can not be
+// used after that.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+
+	if b.conn == nil {
+		return nil
+	}
+
+	err := b.conn.Close()
+	if errors.Is(err, amqp.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (b *Broker) publishing(msg outbox.Message) amqp.Publishing {
+	var headers amqp.Table
+
+	if len(msg.Headers) > 0 {
+		headers = make(amqp.Table, len(msg.Headers))
+
+		for key, value := range msg.Headers {
+			headers[key] = value
+		}
+	}
+
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  b.config.contentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.ID,
+		Timestamp:    msg.CreatedAt,
+		Type:         msg.EventType,
+		Body:         msg.Payload,
+	}
+}
+
+// connect returns the opened channel. If the channel or the connection
+// is closed, they are opened again.
+func (b *Broker) connect() (*amqp.Channel, error) {
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	if b.channel != nil && !b.channel.IsClosed() {
+		return b.channel, nil
+	}
+
+	if b.conn == nil || b.conn.IsClosed() {
+		conn, err := amqp.DialConfig(b.url, b.config.amqpConfig)
+		if err != nil {
+			return nil, fmt.Errorf("can not connect to broker, %w", err)
+		}
+
+		b.conn = conn
+	}
+
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("can not open channel, %w", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		_ = ch.Close()
+
+		return nil, fmt.Errorf("can not enable confirm mode, %w", err)
+	}
+
+	// Only one event is published at once, so at most one event
+	// can be returned.
+	b.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	b.channel = ch
+
+	return ch, nil
+}
+
+// reset closes the current channel, so the new one is opened
+// on the next publishing.
+func (b *Broker) reset() {
+	if b.channel == nil {
+		return
+	}
+
+	_ = b.channel.Close()
+
+	b.channel = nil
+	b.returns = nil
+}
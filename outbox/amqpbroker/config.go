@@ -0,0 +1,105 @@
+package amqpbroker
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// DefaultContentType is the content type of published events.
+	DefaultContentType = "application/json"
+	// DefaultLocale is the locale used to dial RabbitMQ.
+	DefaultLocale = "en_US"
+)
+
+// Route is the destination of the published event.
+type Route struct {
+	Exchange   string
+	RoutingKey string
+}
+
+// RouteFunc maps the event type to the destination of the event.
+type RouteFunc func(eventType string) Route
+
+// defaultRoute publishes events to the default exchange, so the event
+// type is the name of the destination queue.
+func defaultRoute(eventType string) Route {
+	return Route{
+		Exchange:   "",
+		RoutingKey: eventType,
+	}
+}
+
+type config struct {
+	route       RouteFunc
+	mandatory   bool
+	contentType string
+	amqpConfig  amqp.Config
+}
+
+func defaultConfig() config {
+	return config{
+		route:       defaultRoute,
+		mandatory:   true,
+		contentType: DefaultContentType,
+		amqpConfig:  amqp.Config{Locale: DefaultLocale},
+	}
+}
+
+// Option sets specific configuration to the Broker.
+type Option func(config) config
+
+// WithExchange publishes all events to the exchange. The event type
+// is used as routing key.
+func WithExchange(exchange string) Option {
+	return func(c config) config {
+		c.route = func(eventType string) Route {
+			return Route{
+				Exchange:   exchange,
+				RoutingKey: eventType,
+			}
+		}
+
+		return c
+	}
+}
+
+// WithRoute sets custom mapping of the event type to the exchange
+// and routing key.
+func WithRoute(route RouteFunc) Option {
+	return func(c config) config {
+		if route != nil {
+			c.route = route
+		}
+
+		return c
+	}
+}
+
+// WithMandatory sets the mandatory flag of published events. If the flag
+// is set, events that can not be routed to any queue are returned by
+// RabbitMQ and publishing fails with ErrReturned. Enabled by default.
+func WithMandatory(mandatory bool) Option {
+	return func(c config) config {
+		c.mandatory = mandatory
+
+		return c
+	}
+}
+
+// WithContentType sets the content type of published events.
+func WithContentType(contentType string) Option {
+	return func(c config) config {
+		c.contentType = contentType
+
+		return c
+	}
+}
+
+// WithAMQPConfig sets the config used to dial RabbitMQ.
+func WithAMQPConfig(cfg amqp.Config) Option {
+	return func(c config) config {
+		c.amqpConfig = cfg
+
+		return c
+	}
+}
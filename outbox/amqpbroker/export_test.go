@@ -0,0 +1,29 @@
+package amqpbroker
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/Melenium2/go-iobox/outbox"
+)
+
+func RouteOf(eventType string, opts ...Option) Route {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return cfg.route(eventType)
+}
+
+func PublishingOf(msg outbox.Message, opts ...Option) amqp.Publishing {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	b := &Broker{config: cfg}
+
+	return b.publishing(msg)
+}
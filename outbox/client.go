@@ -9,22 +9,114 @@ type Execer interface {
 	ExecContext(context.Context, string, ...any) (sql.Result, error)
 }
 
+// queryer is implemented by Execer which can return rows, like *sql.DB,
+// *sql.Tx and *sql.Conn.
+type queryer interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}
+
+// idsQueryer returns ids of the rows returned by the query.
+type idsQueryer interface {
+	queryIDs(context.Context, string, ...any) ([]string, error)
+}
+
+// asIDsQueryer reports whether the Execer can return rows.
+func asIDsQueryer(tx Execer) (idsQueryer, bool) {
+	switch q := tx.(type) {
+	case idsQueryer:
+		return q, true
+	case queryer:
+		return sqlQueryer{q: q}, true
+	default:
+		return nil, false
+	}
+}
+
+type sqlQueryer struct {
+	q queryer
+}
+
+func (s sqlQueryer) queryIDs(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := make([]string, 0)
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
 // Client provides possibility to set outbox record to the outbox table.
 // Insertion must be in the same transaction as the produced action.
+// Records created with WithPublishAt are published not before the
+// specified time, so the Client can schedule delayed events transactionally.
 type Client interface {
 	WriteOutbox(context.Context, Execer, *Record) error
+	// WriteOutboxBatch writes all records with a single statement and
+	// returns ids of the records that are ignored as duplicates. Duplicates
+	// are reported only if the Execer also implements QueryContext method,
+	// like *sql.DB, *sql.Tx and *sql.Conn do, or is created with PgxExecer,
+	// otherwise nil is returned.
+	WriteOutboxBatch(context.Context, Execer, []*Record) ([]string, error)
 }
 
 type client struct {
-	storage *defaultStorage
+	storage Storage
+	// notifier is nil if LISTEN/NOTIFY is disabled or not
+	// supported by the storage.
+	notifier notifier
 }
 
-func newClient(storage *defaultStorage) *client {
-	return &client{
+func newClient(storage Storage, notify bool) *client {
+	c := &client{
 		storage: storage,
 	}
+
+	if n, ok := storage.(notifier); ok && notify {
+		c.notifier = n
+	}
+
+	return c
 }
 
 func (c *client) WriteOutbox(ctx context.Context, tx Execer, record *Record) error {
-	return c.storage.Insert(ctx, tx, record)
+	if err := c.storage.Insert(ctx, tx, record); err != nil {
+		return err
+	}
+
+	if c.notifier != nil {
+		return c.notifier.Notify(ctx, tx)
+	}
+
+	return nil
+}
+
+func (c *client) WriteOutboxBatch(ctx context.Context, tx Execer, records []*Record) ([]string, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	duplicates, err := c.storage.InsertBatch(ctx, tx, records)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.notifier != nil {
+		return duplicates, c.notifier.Notify(ctx, tx)
+	}
+
+	return duplicates, nil
 }
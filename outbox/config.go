@@ -17,29 +17,66 @@ const (
 	// of the current event is canceled. The current event marked as 'not yet published', and
 	// processing continues.
 	DefaultPublishTimeout = 2 * time.Second
+	// DefaultBatchSize is the max number of events fetched from the outbox
+	// table in one batch.
+	DefaultBatchSize = 100
+	// DefaultLeaseTimeout is the time after which events stuck in 'progress'
+	// status are fetched again. Events get stuck if the worker dies before
+	// their status is updated. The lease must be longer than the time needed
+	// to publish the whole batch.
+	DefaultLeaseTimeout = 5 * time.Minute
+	// DefaultRetryAttempts is the max attempts before event marks
+	// as 'dead'. 'Dead' means that the event will no longer be
+	// published.
+	DefaultRetryAttempts = 5
+	// DefaultTableName is the name of the outbox table.
+	DefaultTableName = "__outbox_table"
+	// DefaultPublishConcurrency is the number of events of the batch
+	// published at the same time.
+	DefaultPublishConcurrency = 1
 )
 
-// ErrorCallback prototype of function that is called if errors occurs
-// during outbox process.
-type ErrorCallback func(err error)
+type (
+	// DeadCallback prototype of function that is called if event is 'dead'.
+	DeadCallback func(eventID string, msg string)
+	// ErrorCallback prototype of function that is called if errors occurs
+	// during outbox process.
+	ErrorCallback func(err error)
+)
 
-func nopCallback(error) {}
+func nopDeadCallback(string, string) {}
+func nopCallback(error)              {}
 
 type config struct {
-	iterationRate time.Duration
-	iterationSeed int
-	timeout       time.Duration
-	retention     retention.Config
-	onError       ErrorCallback
+	iterationRate    time.Duration
+	iterationSeed    int
+	timeout          time.Duration
+	batchSize        int
+	concurrency      int
+	leaseTimeout     time.Duration
+	maxRetryAttempts int
+	notifyDSN        string
+	tableName        string
+	schema           string
+	retention        retention.Config
+	storage          Storage
+	onDead           DeadCallback
+	onError          ErrorCallback
 }
 
 func defaultConfig() config {
 	return config{
-		iterationRate: DefaultIterationRate,
-		iterationSeed: DefaultIterationSeed,
-		timeout:       DefaultPublishTimeout,
-		retention:     retention.Config{},
-		onError:       nopCallback,
+		iterationRate:    DefaultIterationRate,
+		iterationSeed:    DefaultIterationSeed,
+		timeout:          DefaultPublishTimeout,
+		batchSize:        DefaultBatchSize,
+		concurrency:      DefaultPublishConcurrency,
+		leaseTimeout:     DefaultLeaseTimeout,
+		maxRetryAttempts: DefaultRetryAttempts,
+		tableName:        DefaultTableName,
+		retention:        retention.Config{},
+		onDead:           nopDeadCallback,
+		onError:          nopCallback,
 	}
 }
 
@@ -75,6 +112,105 @@ func WithPublishTimeout(dur time.Duration) Option {
 	}
 }
 
+// WithBatchSize sets the max number of events fetched from the outbox
+// table in one batch. Non-positive values are ignored.
+func WithBatchSize(size int) Option {
+	return func(c config) config {
+		if size > 0 {
+			c.batchSize = size
+		}
+
+		return c
+	}
+}
+
+// WithPublishConcurrency sets the number of events of the fetched batch
+// published at the same time. Events with the same ordering key are still
+// published one after another in the order of the batch. Callbacks can be
+// called concurrently if n is greater than one. Non-positive values are
+// ignored.
+func WithPublishConcurrency(n int) Option {
+	return func(c config) config {
+		if n > 0 {
+			c.concurrency = n
+		}
+
+		return c
+	}
+}
+
+// WithLeaseTimeout sets the time after which events stuck in 'progress'
+// status are fetched and published again. Non-positive values are ignored.
+func WithLeaseTimeout(dur time.Duration) Option {
+	return func(c config) config {
+		if dur > 0 {
+			c.leaseTimeout = dur
+		}
+
+		return c
+	}
+}
+
+// WithMaxRetryAttempt sets custom max attempts for publishing event.
+func WithMaxRetryAttempt(maxAttempt int) Option {
+	return func(c config) config {
+		c.maxRetryAttempts = maxAttempt
+
+		return c
+	}
+}
+
+// WithListenNotify enables LISTEN/NOTIFY wakeups of the outbox worker.
+// Client notifies the worker in the same transaction in which the event
+// is written, and the worker publishes the event as soon as the transaction
+// is committed. The worker still polls the outbox table with the iteration
+// rate in case a notification is lost.
+//
+// Arguments:
+//
+//	dsn - connection string to the database which is used for a dedicated
+//	LISTEN connection.
+func WithListenNotify(dsn string) Option {
+	return func(c config) config {
+		c.notifyDSN = dsn
+
+		return c
+	}
+}
+
+// WithTableName sets custom name of the outbox table. Use it to run several
+// independent outboxes in one database. Migrations of the table are tracked
+// in the separate '<name>_schema' table.
+func WithTableName(name string) Option {
+	return func(c config) config {
+		c.tableName = name
+
+		return c
+	}
+}
+
+// WithSchema sets the database schema of the outbox table and its
+// migrations table. The schema is created if it does not exist. By default,
+// the current schema of the connection is used.
+func WithSchema(schema string) Option {
+	return func(c config) config {
+		c.schema = schema
+
+		return c
+	}
+}
+
+// WithStorage sets custom Storage of outbox records instead of the
+// Postgres table. Options of the table and fetching are not applied
+// to the custom Storage.
+func WithStorage(storage Storage) Option {
+	return func(c config) config {
+		c.storage = storage
+
+		return c
+	}
+}
+
 // WithRetention sets the retention configuration for outbox table.
 //
 // Arguments:
@@ -93,6 +229,17 @@ func WithRetention(eraseInterval time.Duration, windowDays int) Option {
 	}
 }
 
+// OnDeadCallback sets custom callback for each event that can not
+// be published and marks as 'dead'. Function fires if 'dead' event
+// detected.
+func OnDeadCallback(callback DeadCallback) Option {
+	return func(c config) config {
+		c.onDead = callback
+
+		return c
+	}
+}
+
 // ErrorCallback sets custom callback that is called if errors occurs
 // during outbox process.
 func OnErrorCallback(callback ErrorCallback) Option {
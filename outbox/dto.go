@@ -1,25 +1,36 @@
 package outbox
 
 import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"sort"
 	"time"
 )
 
 type dtoRecord struct {
-	ID        string    `db:"id"`
-	Status    string    `db:"status"`
-	EventType string    `db:"event_type"`
-	Payload   []byte    `db:"payload"`
-	CreatedAt time.Time `db:"created_at"`
+	ID          string    `db:"id"`
+	Status      string    `db:"status"`
+	EventType   string    `db:"event_type"`
+	Payload     []byte    `db:"payload"`
+	Headers     []byte    `db:"headers"`
+	OrderingKey string    `db:"ordering_key"`
+	Attempt     int       `db:"attempt"`
+	CreatedAt   time.Time `db:"created_at"`
 }
 
-func newDtoRecord(id, status, eventType string, payload []byte, createdAt time.Time) *dtoRecord {
+func newDtoRecord(
+	id, status, eventType string, payload, headers []byte, orderingKey string, attempt int, createdAt time.Time,
+) *dtoRecord {
 	return &dtoRecord{
-		ID:        id,
-		Status:    status,
-		EventType: eventType,
-		Payload:   payload,
-		CreatedAt: createdAt,
+		ID:          id,
+		Status:      status,
+		EventType:   eventType,
+		Payload:     payload,
+		Headers:     headers,
+		OrderingKey: orderingKey,
+		Attempt:     attempt,
+		CreatedAt:   createdAt.UTC(),
 	}
 }
 
@@ -34,11 +45,44 @@ func (dp *dtoPayload) MarshalJSON() ([]byte, error) {
 func makeRecord(dto *dtoRecord) (*Record, error) {
 	payload := dtoPayload{Body: dto.Payload}
 
-	return newFullRecord(dto.ID, Status(dto.Status), dto.EventType, &payload), nil
+	var headers map[string]string
+
+	if len(dto.Headers) > 0 {
+		if err := json.Unmarshal(dto.Headers, &headers); err != nil {
+			return nil, fmt.Errorf("headers of record %q not unmarshaled, %w", dto.ID, err)
+		}
+	}
+
+	return newFullRecord(
+		dto.ID,
+		Status(dto.Status),
+		dto.EventType,
+		&payload,
+		headers,
+		dto.OrderingKey,
+		dto.Attempt,
+		dto.CreatedAt,
+	), nil
+}
+
+// makeHeaders converts record headers to the value of headers column.
+func makeHeaders(headers map[string]string) (sql.NullString, error) {
+	if len(headers) == 0 {
+		return sql.NullString{}, nil
+	}
+
+	b, err := json.Marshal(headers)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: string(b), Valid: true}, nil
 }
 
 func makeRecords(dtos []*dtoRecord) ([]*Record, error) {
-	sort.Slice(dtos, func(i, j int) bool {
+	// The sort is stable to keep the order of records created in the
+	// same transaction, which is the order of the sequence.
+	sort.SliceStable(dtos, func(i, j int) bool {
 		t1 := dtos[i].CreatedAt
 		t2 := dtos[j].CreatedAt
 
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -45,3 +46,59 @@ func TestMakeRecords_Should_sorts_dtos_by_created_at_in_asc_order(t *testing.T)
 	require.NoError(t, err)
 	assert.Equal(t, expected, res)
 }
+
+func TestMakeRecords_Should_keep_order_of_dtos_with_same_created_at(t *testing.T) {
+	var (
+		createdAt = time.Date(2000, 1, 1, 1, 13, 0, 0, time.UTC)
+		dtos      = make([]*outbox.DTORecord, 100)
+		expected  []string
+	)
+
+	// Records of the same transaction have the same created_at,
+	// they are interleaved with records of other transactions.
+	for i := range dtos {
+		dtos[i] = &outbox.DTORecord{
+			ID:        uuid.NewString(),
+			Status:    "progress",
+			EventType: "topic1",
+			Payload:   []byte("{}"),
+			CreatedAt: createdAt.Add(time.Duration(len(dtos)-i) % 3 * time.Minute),
+		}
+	}
+
+	for tx := range 3 {
+		for _, dto := range dtos {
+			if dto.CreatedAt.Equal(createdAt.Add(time.Duration(tx) * time.Minute)) {
+				expected = append(expected, dto.ID)
+			}
+		}
+	}
+
+	res, err := outbox.MakeRecrods(dtos)
+	require.NoError(t, err)
+
+	ids := make([]string, len(res))
+	for i, record := range res {
+		ids[i] = record.ID()
+	}
+
+	assert.Equal(t, expected, ids)
+}
+
+func TestMakeRecords_Should_restore_record_headers(t *testing.T) {
+	dtos := []*outbox.DTORecord{
+		{
+			ID:        outbox.ID1(),
+			Status:    "progress",
+			EventType: "topic1",
+			Payload:   []byte("{}"),
+			Headers:   []byte(`{"a": "b"}`),
+			CreatedAt: time.Date(2000, 1, 1, 1, 13, 0, 0, time.UTC),
+		},
+	}
+
+	res, err := outbox.MakeRecrods(dtos)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, map[string]string{"a": "b"}, res[0].Message(nil).Headers)
+}
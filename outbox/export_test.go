@@ -3,13 +3,14 @@ package outbox
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type PayloadMarshaler = dtoPayload
 
-type Storage = defaultStorage
+type PostgresStorage = defaultStorage
 
 var (
 	id1 = uuid.NewString()
@@ -32,31 +33,76 @@ func ID3() string {
 func Record1() *Record {
 	payload := dtoPayload{Body: []byte("{}")}
 
-	return newFullRecord(id1, Progress, "topic1", &payload)
+	return newFullRecord(id1, Progress, "topic1", &payload, nil, "", 0, time.Date(2000, 1, 1, 1, 13, 0, 0, time.UTC))
 }
 
 func Record2() *Record {
 	payload := dtoPayload{Body: []byte("{}")}
 
-	return newFullRecord(id2, Progress, "topic1", &payload)
+	return newFullRecord(id2, Progress, "topic1", &payload, nil, "", 0, time.Date(2000, 1, 1, 1, 15, 0, 0, time.UTC))
 }
 
 func Record3() *Record {
 	payload := dtoPayload{Body: []byte("{}")}
 
-	return newFullRecord(id3, Done, "topic1", &payload)
+	return newFullRecord(id3, Done, "topic1", &payload, nil, "", 0, time.Date(2000, 1, 1, 1, 17, 0, 0, time.UTC))
 }
 
-func NewStorage(conn *sql.DB) *defaultStorage {
-	return newStorage(conn)
+func NewStorage(conn *sql.DB, opts ...Option) *PostgresStorage {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return newStorage(conn, cfg)
+}
+
+func LeaseTimeout(opts ...Option) time.Duration {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return cfg.leaseTimeout
 }
 
 func (o *Outbox) Iteration() error {
 	return o.iteration(context.Background())
 }
 
+func (o *Outbox) PublishBatch(records []*Record) int {
+	return o.publishBatch(context.Background(), records)
+}
+
+func (o *Outbox) FailOrDead(record *Record, err error) *Record {
+	return o.failOrDead(record, err)
+}
+
+func RecordWithAttempt(attempt int, status Status) *Record {
+	rec := Record1()
+
+	rec.attempt.attempt = attempt
+	rec.status = status
+
+	return rec
+}
+
+func InsertPlaceholders(offset int) string {
+	return insertPlaceholders(offset)
+}
+
 type DTORecord = dtoRecord
 
+func (r *Record) Message(payload []byte) Message {
+	return r.message(payload)
+}
+
+func NewBrokerAdapter(broker Broker) MessageBroker {
+	return newBrokerAdapter(broker)
+}
+
 func MakeRecrods(dtos []*DTORecord) ([]*Record, error) {
 	return makeRecords(dtos)
 }
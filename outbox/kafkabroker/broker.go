@@ -0,0 +1,106 @@
+// Package kafkabroker implements outbox.Broker and outbox.MessageBroker
+// on top of Kafka.
+package kafkabroker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/Melenium2/go-iobox/outbox"
+)
+
+const (
+	// HeaderEventType is the header with the event type of the message.
+	HeaderEventType = "event-type"
+	// HeaderEventID is the header with the event ID of the message.
+	HeaderEventID = "event-id"
+)
+
+var (
+	_ outbox.Broker        = (*Broker)(nil)
+	_ outbox.MessageBroker = (*Broker)(nil)
+)
+
+// Writer writes messages to Kafka. *kafka.Writer implements the
+// interface. The writer must not set the topic, because the topic
+// is set for each message.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Broker publishes outbox events to Kafka.
+//
+// The event ID is used as the message key, so events with the same ID
+// are written to the same partition. If the event has the ordering key,
+// it is used as the message key instead, so events with the same
+// ordering key are consumed in the order of publishing.
+type Broker struct {
+	writer Writer
+	config config
+}
+
+// New creates new Broker writing messages with the writer.
+func New(writer Writer, opts ...Option) *Broker {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return &Broker{
+		writer: writer,
+		config: cfg,
+	}
+}
+
+// Publish publishes the payload with the event type subject.
+func (b *Broker) Publish(ctx context.Context, subject string, payload []byte) error {
+	return b.PublishMessage(ctx, outbox.Message{
+		EventType: subject,
+		Payload:   payload,
+	})
+}
+
+// PublishMessage publishes the message to the topic of its event type.
+func (b *Broker) PublishMessage(ctx context.Context, msg outbox.Message) error {
+	topic := b.config.topic(msg.EventType)
+
+	if err := b.writer.WriteMessages(ctx, b.message(topic, msg)); err != nil {
+		return fmt.Errorf("message not written to topic %q, %w", topic, err)
+	}
+
+	return nil
+}
+
+func (b *Broker) message(topic string, msg outbox.Message) kafka.Message {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+2)
+
+	headers = append(headers, kafka.Header{Key: HeaderEventType, Value: []byte(msg.EventType)})
+
+	if msg.ID != "" {
+		headers = append(headers, kafka.Header{Key: HeaderEventID, Value: []byte(msg.ID)})
+	}
+
+	for key, value := range msg.Headers {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+
+	var key []byte
+
+	switch {
+	case msg.OrderingKey != "":
+		key = []byte(msg.OrderingKey)
+	case msg.ID != "":
+		key = []byte(msg.ID)
+	}
+
+	return kafka.Message{
+		Topic:   topic,
+		Key:     key,
+		Value:   msg.Payload,
+		Headers: headers,
+		Time:    msg.CreatedAt,
+	}
+}
@@ -0,0 +1,111 @@
+package kafkabroker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/kafkabroker"
+)
+
+// fakeWriter is in-process fake of the Kafka writer.
+type fakeWriter struct {
+	err      error
+	messages []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.messages = append(w.messages, msgs...)
+
+	return nil
+}
+
+func TestPublishMessage_Should_use_record_id_as_message_key(t *testing.T) {
+	var (
+		writer    = &fakeWriter{}
+		broker    = kafkabroker.New(writer)
+		createdAt = time.Date(2000, 1, 1, 1, 13, 0, 0, time.UTC)
+	)
+
+	msg := outbox.Message{
+		ID:        "1",
+		EventType: "order.created",
+		Payload:   []byte("{}"),
+		Headers:   map[string]string{"trace-id": "2"},
+		CreatedAt: createdAt,
+	}
+
+	err := broker.PublishMessage(context.Background(), msg)
+	require.NoError(t, err)
+
+	expected := []kafka.Message{
+		{
+			Topic: "order.created",
+			Key:   []byte("1"),
+			Value: []byte("{}"),
+			Headers: []kafka.Header{
+				{Key: kafkabroker.HeaderEventType, Value: []byte("order.created")},
+				{Key: kafkabroker.HeaderEventID, Value: []byte("1")},
+				{Key: "trace-id", Value: []byte("2")},
+			},
+			Time: createdAt,
+		},
+	}
+	assert.Equal(t, expected, writer.messages)
+}
+
+func TestPublishMessage_Should_use_ordering_key_as_message_key(t *testing.T) {
+	var (
+		writer = &fakeWriter{}
+		broker = kafkabroker.New(writer, kafkabroker.WithTopic("orders"))
+	)
+
+	msg := outbox.Message{
+		ID:          "1",
+		EventType:   "order.created",
+		Payload:     []byte("{}"),
+		OrderingKey: "order-1",
+	}
+
+	err := broker.PublishMessage(context.Background(), msg)
+	require.NoError(t, err)
+	require.Len(t, writer.messages, 1)
+	assert.Equal(t, "orders", writer.messages[0].Topic)
+	assert.Equal(t, []byte("order-1"), writer.messages[0].Key)
+}
+
+func TestPublish_Should_map_event_type_to_topic(t *testing.T) {
+	var (
+		writer = &fakeWriter{}
+		topic  = func(eventType string) string {
+			return "v1." + eventType
+		}
+		broker = kafkabroker.New(writer, kafkabroker.WithTopicFunc(topic))
+	)
+
+	err := broker.Publish(context.Background(), "order.created", []byte("{}"))
+	require.NoError(t, err)
+	require.Len(t, writer.messages, 1)
+	assert.Equal(t, "v1.order.created", writer.messages[0].Topic)
+	assert.Nil(t, writer.messages[0].Key)
+}
+
+func TestPublish_Should_return_error_of_writer(t *testing.T) {
+	var (
+		writeErr = errors.New("leader not available")
+		broker   = kafkabroker.New(&fakeWriter{err: writeErr})
+	)
+
+	err := broker.Publish(context.Background(), "order.created", []byte("{}"))
+	assert.ErrorIs(t, err, writeErr)
+}
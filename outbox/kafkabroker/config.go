@@ -0,0 +1,44 @@
+package kafkabroker
+
+// TopicFunc maps the event type to the topic of the event.
+type TopicFunc func(eventType string) string
+
+// defaultTopic publishes events to the topic named as event type.
+func defaultTopic(eventType string) string {
+	return eventType
+}
+
+type config struct {
+	topic TopicFunc
+}
+
+func defaultConfig() config {
+	return config{
+		topic: defaultTopic,
+	}
+}
+
+// Option sets specific configuration to the Broker.
+type Option func(config) config
+
+// WithTopic publishes all events to the topic.
+func WithTopic(topic string) Option {
+	return func(c config) config {
+		c.topic = func(string) string {
+			return topic
+		}
+
+		return c
+	}
+}
+
+// WithTopicFunc sets custom mapping of the event type to the topic.
+func WithTopicFunc(topic TopicFunc) Option {
+	return func(c config) config {
+		if topic != nil {
+			c.topic = topic
+		}
+
+		return c
+	}
+}
@@ -0,0 +1,46 @@
+package outbox
+
+import (
+	"context"
+	"time"
+)
+
+// Message is an envelope of the outbox event that is sent to the MessageBroker.
+type Message struct {
+	// ID is a unique id of the outbox record. Broker can use it
+	// to deduplicate published messages.
+	ID string
+	// EventType is a topic to which event should be published.
+	EventType string
+	// Payload is the body to be published.
+	Payload []byte
+	// Headers is a custom metadata of the event.
+	Headers map[string]string
+	// OrderingKey is the key of the events that are published in
+	// insertion order. Empty if the event has no ordering key.
+	OrderingKey string
+	// CreatedAt is the time when the event was written to the outbox table.
+	CreatedAt time.Time
+}
+
+// MessageBroker publishes events together with the metadata
+// stored in the outbox table.
+type MessageBroker interface {
+	PublishMessage(ctx context.Context, msg Message) error
+}
+
+// brokerAdapter allows to use Broker where MessageBroker is required.
+// Only event type and payload of the message are passed to the Broker.
+type brokerAdapter struct {
+	broker Broker
+}
+
+func newBrokerAdapter(broker Broker) *brokerAdapter {
+	return &brokerAdapter{
+		broker: broker,
+	}
+}
+
+func (a *brokerAdapter) PublishMessage(ctx context.Context, msg Message) error {
+	return a.broker.Publish(ctx, msg.EventType, msg.Payload)
+}
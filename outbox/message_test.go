@@ -0,0 +1,59 @@
+package outbox_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/outbox"
+)
+
+type subjectBroker struct {
+	subject string
+	payload []byte
+}
+
+func (b *subjectBroker) Publish(_ context.Context, subject string, payload []byte) error {
+	b.subject = subject
+	b.payload = payload
+
+	return nil
+}
+
+func TestRecord_Message(t *testing.T) {
+	t.Run("should create message envelope from the record", func(t *testing.T) {
+		var (
+			payload = outbox.PayloadMarshaler{Body: []byte("{}")}
+			headers = map[string]string{"trace-id": "1"}
+		)
+
+		record := outbox.NewRecord(outbox.ID1(), "topic1", &payload, outbox.WithHeaders(headers))
+
+		msg := record.Message([]byte("{}"))
+		assert.Equal(t, outbox.ID1(), msg.ID)
+		assert.Equal(t, "topic1", msg.EventType)
+		assert.Equal(t, []byte("{}"), msg.Payload)
+		assert.Equal(t, headers, msg.Headers)
+	})
+}
+
+func TestBrokerAdapter_PublishMessage(t *testing.T) {
+	t.Run("should publish message payload to the event type subject", func(t *testing.T) {
+		broker := &subjectBroker{}
+
+		msg := outbox.Message{
+			ID:        outbox.ID1(),
+			EventType: "topic1",
+			Payload:   []byte("{}"),
+			CreatedAt: time.Now(),
+		}
+
+		err := outbox.NewBrokerAdapter(broker).PublishMessage(context.Background(), msg)
+		require.NoError(t, err)
+		assert.Equal(t, "topic1", broker.subject)
+		assert.Equal(t, []byte("{}"), broker.payload)
+	})
+}
@@ -1 +1 @@
-drop table if exists __outbox_table;
+drop table if exists {{.Table}};
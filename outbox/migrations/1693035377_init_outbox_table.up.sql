@@ -1,4 +1,4 @@
-create table if not exists __outbox_table (
+create table if not exists {{.Table}} (
     id varchar(36) not null primary key,
     status varchar(12),
     event_type varchar(255) not null,
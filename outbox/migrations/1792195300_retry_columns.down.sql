@@ -0,0 +1,4 @@
+alter table if exists {{.Table}}
+	drop column if exists attempt,
+	drop column if exists error_message,
+	drop column if exists next_attempt;
@@ -0,0 +1,2 @@
+alter table if exists {{.Table}}
+	drop column if exists headers;
@@ -0,0 +1,3 @@
+alter table if exists {{.Table}}
+	drop column if exists ordering_key,
+	drop column if exists seq;
@@ -0,0 +1,6 @@
+alter table if exists {{.Table}}
+	add column ordering_key varchar(255),
+	add column seq bigserial;
+
+create index if not exists {{.Table.Index "ordering_key_seq_idx"}} on {{.Table}} (ordering_key, seq)
+	where ordering_key is not null;
@@ -0,0 +1,2 @@
+alter table if exists {{.Table}}
+	add column publish_at timestamp;
@@ -2,5 +2,8 @@ package migrations
 
 import "embed"
 
+// FS contains migrations templates of the outbox table, the table
+// name is substituted by migration.TemplateFS.
+//
 //go:embed *.sql
 var FS embed.FS
@@ -0,0 +1,18 @@
+create table if not exists {{.Table}} (
+    seq bigint not null auto_increment primary key,
+    id varchar(36) not null,
+    status varchar(12),
+    event_type varchar(255) not null,
+    payload json not null,
+    headers json,
+    ordering_key varchar(255),
+    attempt smallint not null default 0,
+    error_message text,
+    next_attempt datetime(6),
+    publish_at datetime(6),
+    created_at datetime(6) not null default (utc_timestamp(6)),
+    updated_at datetime(6) not null default (utc_timestamp(6)),
+    unique key {{.Table.Index "id_idx"}} (id),
+    key {{.Table.Index "ordering_key_seq_idx"}} (ordering_key, seq),
+    key {{.Table.Index "created_at_idx"}} (created_at, seq)
+);
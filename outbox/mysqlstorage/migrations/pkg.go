@@ -0,0 +1,9 @@
+package migrations
+
+import "embed"
+
+// FS contains MySQL migrations templates of the outbox table, the table
+// name is substituted by migration.TemplateFS.
+//
+//go:embed *.sql
+var FS embed.FS
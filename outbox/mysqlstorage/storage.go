@@ -0,0 +1,497 @@
+// Package mysqlstorage implements outbox.Storage on top of MySQL 8 or
+// MariaDB 10.6 and newer. Both support `for update skip locked`, so
+// several workers can fetch records from the same table concurrently.
+//
+// The connection must be opened with parseTime=true parameter and
+// the default UTC location.
+//
+//	db, _ := sql.Open("mysql", "user:pass@tcp(localhost:3306)/app?parseTime=true")
+//
+//	ob := outbox.NewOutbox(broker, nil, outbox.WithStorage(mysqlstorage.New(db)))
+package mysqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Melenium2/go-iobox/migration"
+	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/mysqlstorage/migrations"
+)
+
+var _ outbox.Storage = (*Storage)(nil)
+
+// defaultMigrationsTable is the migrations table of the outbox
+// table with default name.
+const defaultMigrationsTable = "outbox_schema"
+
+const (
+	insertColumns      = "id, event_type, payload, headers, ordering_key, publish_at"
+	insertColumnsCount = 6
+	// maxInsertBatch is the max number of records inserted by one
+	// statement. MySQL allows max 65535 parameters in the query.
+	maxInsertBatch = 10000
+)
+
+// queryer is implemented by outbox.Execer which can return rows,
+// like *sql.DB, *sql.Tx and *sql.Conn.
+type queryer interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}
+
+// Storage stores outbox records in the MySQL table.
+type Storage struct {
+	conn  *sql.DB
+	table migration.Table
+	// tableName is quoted name of the table.
+	tableName    string
+	batchSize    int
+	leaseTimeout time.Duration
+}
+
+// New creates new Storage. The table is created by InitOutboxTable.
+func New(conn *sql.DB, opts ...Option) *Storage {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	table := migration.Table{
+		Name:    cfg.tableName,
+		Dialect: migration.MySQL,
+	}
+
+	return &Storage{
+		conn:         conn,
+		table:        table,
+		tableName:    table.String(),
+		batchSize:    cfg.batchSize,
+		leaseTimeout: cfg.leaseTimeout,
+	}
+}
+
+func (s *Storage) InitOutboxTable(ctx context.Context) error {
+	m := migration.New()
+
+	fsys := migration.TemplateFS(migrations.FS, s.table)
+
+	if err := m.SetupMySQLFS(ctx, s.conn, fsys, s.migrationsTable()); err != nil {
+		return fmt.Errorf("failed to setup outbox migrations, %w", err)
+	}
+
+	err := m.Up()
+	if err == nil {
+		return nil
+	}
+
+	_ = m.Down()
+
+	return fmt.Errorf("failed to run migrations, %w", err)
+}
+
+// migrationsTable returns the name of the table with migrations
+// version of the outbox table.
+func (s *Storage) migrationsTable() string {
+	if s.table.Name == outbox.DefaultTableName {
+		return defaultMigrationsTable
+	}
+
+	return s.table.Name + "_schema"
+}
+
+// Fetch claims the next batch of records in the transaction. MySQL does
+// not support `returning`, so ids of the records are locked first, then
+// the records are updated and selected by ids.
+func (s *Storage) Fetch(ctx context.Context, fetchTime time.Time) ([]*outbox.Record, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	ids, err := s.claim(ctx, tx, fetchTime)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	if len(ids) == 0 {
+		return nil, outbox.ErrNoRecrods
+	}
+
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, string(outbox.Progress))
+
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = ?, " +
+		" 			updated_at = utc_timestamp(6) " +
+		" 		where id in " + placeholders(len(ids)) + ";"
+
+	if _, err = tx.ExecContext(ctx, sqlStr, args...); err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	records, err := s.selectRecords(ctx, tx, args[1:])
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	return records, nil
+}
+
+// claim locks ids of the records which are ready to be published. Rows
+// locked by another worker are skipped.
+func (s *Storage) claim(ctx context.Context, tx *sql.Tx, fetchTime time.Time) ([]string, error) {
+	sqlStr := "select t.id from " + s.tableName + " t " +
+		" 		where " +
+		" 			(" +
+		" 				(t.status is null and (t.publish_at is null or t.publish_at <= ?)) or " +
+		" 				(t.status = 'failed' and t.next_attempt <= ?) or " +
+		" 				(t.status = 'progress' and t.updated_at <= ?) " +
+		" 			) and ( " +
+		" 				t.ordering_key is null or not exists ( " +
+		" 					select 1 from " + s.tableName + " p " +
+		" 					where p.ordering_key = t.ordering_key " +
+		" 						and p.seq < t.seq " +
+		" 						and (p.status is null or p.status in ('progress', 'failed')) " +
+		" 				) " +
+		" 			) " +
+		" 		order by t.created_at, t.seq " +
+		" 		limit ? " +
+		" 		for update skip locked;"
+
+	leaseDeadline := fetchTime.Add(-s.leaseTimeout)
+
+	rows, err := tx.QueryContext(ctx, sqlStr, fetchTime, fetchTime, leaseDeadline, s.batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanIDs(rows)
+}
+
+func (s *Storage) selectRecords(ctx context.Context, tx *sql.Tx, ids []any) ([]*outbox.Record, error) {
+	sqlStr := "select id, status, event_type, payload, headers, ordering_key, attempt, created_at " +
+		" 		from " + s.tableName +
+		" 		where id in " + placeholders(len(ids)) +
+		" 		order by created_at, seq;"
+
+	rows, err := tx.QueryContext(ctx, sqlStr, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	records := make([]*outbox.Record, 0, len(ids))
+
+	for rows.Next() {
+		var (
+			state       outbox.RecordState
+			status      sql.NullString
+			headers     []byte
+			orderingKey sql.NullString
+		)
+
+		err = rows.Scan(
+			&state.ID, &status, &state.EventType, &state.Payload, &headers, &orderingKey, &state.Attempt, &state.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(headers) > 0 {
+			if err = json.Unmarshal(headers, &state.Headers); err != nil {
+				return nil, fmt.Errorf("headers of record %q not unmarshaled, %w", state.ID, err)
+			}
+		}
+
+		state.Status = outbox.Status(status.String)
+		state.OrderingKey = orderingKey.String
+		state.CreatedAt = state.CreatedAt.UTC()
+
+		records = append(records, outbox.RestoreRecord(state))
+	}
+
+	return records, rows.Err()
+}
+
+func (s *Storage) Update(ctx context.Context, records []*outbox.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var recordsStatus sql.NullString
+
+	if records[0].Status() != outbox.Null {
+		recordsStatus = sql.NullString{String: string(records[0].Status()), Valid: true}
+	}
+
+	args := make([]any, 0, len(records)+1)
+	args = append(args, recordsStatus)
+
+	for _, record := range records {
+		args = append(args, record.ID())
+	}
+
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = ?, " +
+		" 			updated_at = utc_timestamp(6) " +
+		" 		where id in " + placeholders(len(records)) + ";"
+
+	_, err := s.conn.ExecContext(ctx, sqlStr, args...)
+
+	return err
+}
+
+// UpdateAttempts updates status of the provided records together with
+// the information about the last failed attempt.
+func (s *Storage) UpdateAttempts(ctx context.Context, records []*outbox.Record) error {
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = ?, " +
+		" 			attempt = ?, " +
+		" 			error_message = ?, " +
+		" 			next_attempt = ?, " +
+		" 			updated_at = utc_timestamp(6) " +
+		" 		where id = ?;"
+
+	for _, curr := range records {
+		var (
+			recordStatus    sql.NullString
+			errorMessage    sql.NullString
+			attemptDeadline sql.NullTime
+		)
+
+		if curr.Status() != outbox.Null {
+			recordStatus = sql.NullString{String: string(curr.Status()), Valid: true}
+		}
+
+		if curr.ErrorMessage() != "" {
+			errorMessage = sql.NullString{String: curr.ErrorMessage(), Valid: true}
+		}
+
+		if !curr.NextAttempt().IsZero() {
+			attemptDeadline = sql.NullTime{Time: curr.NextAttempt(), Valid: true}
+		}
+
+		_, err := s.conn.ExecContext(
+			ctx,
+			sqlStr,
+			recordStatus,
+			curr.Attempt(),
+			errorMessage,
+			attemptDeadline,
+			curr.ID(),
+		)
+		if err != nil {
+			return fmt.Errorf("error while updating records, %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) Insert(ctx context.Context, tx outbox.Execer, record *outbox.Record) error {
+	values, err := insertValues(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, s.insertSQL(1), values...)
+
+	return err
+}
+
+// InsertBatch inserts records with a single multi-row statement and returns
+// ids of the ignored duplicates. Duplicates are returned only if tx can
+// query rows, they are selected before the insertion.
+func (s *Storage) InsertBatch(ctx context.Context, tx outbox.Execer, records []*outbox.Record) ([]string, error) {
+	q, ok := tx.(queryer)
+
+	duplicates := make([]string, 0)
+
+	for start := 0; start < len(records); start += maxInsertBatch {
+		end := min(start+maxInsertBatch, len(records))
+
+		if ok {
+			curr, err := s.duplicates(ctx, q, records[start:end])
+			if err != nil {
+				return nil, err
+			}
+
+			duplicates = append(duplicates, curr...)
+		}
+
+		if err := s.insertBatch(ctx, tx, records[start:end]); err != nil {
+			return nil, err
+		}
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	return duplicates, nil
+}
+
+func (s *Storage) insertBatch(ctx context.Context, tx outbox.Execer, records []*outbox.Record) error {
+	args := make([]any, 0, len(records)*insertColumnsCount)
+
+	for _, record := range records {
+		values, err := insertValues(record)
+		if err != nil {
+			return err
+		}
+
+		args = append(args, values...)
+	}
+
+	_, err := tx.ExecContext(ctx, s.insertSQL(len(records)), args...)
+
+	return err
+}
+
+// duplicates returns ids of the records which already exist in the table
+// or are repeated in the batch.
+func (s *Storage) duplicates(ctx context.Context, q queryer, records []*outbox.Record) ([]string, error) {
+	args := make([]any, 0, len(records))
+
+	for _, record := range records {
+		args = append(args, record.ID())
+	}
+
+	sqlStr := "select id from " + s.tableName + " where id in " + placeholders(len(records)) + ";"
+
+	rows, err := q.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(records))
+
+	for _, id := range ids {
+		seen[id] = struct{}{}
+	}
+
+	duplicates := make([]string, 0)
+
+	for _, record := range records {
+		if _, ok := seen[record.ID()]; ok {
+			duplicates = append(duplicates, record.ID())
+
+			continue
+		}
+
+		seen[record.ID()] = struct{}{}
+	}
+
+	return duplicates, nil
+}
+
+// insertSQL returns the statement which inserts n records and ignores
+// the records with existing id.
+func (s *Storage) insertSQL(n int) string {
+	rows := make([]string, n)
+
+	for i := range n {
+		rows[i] = placeholders(insertColumnsCount)
+	}
+
+	return "insert into " + s.tableName + " (" + insertColumns + ") " +
+		" values " + strings.Join(rows, ", ") +
+		" on duplicate key update id = id;"
+}
+
+func (s *Storage) Purge(ctx context.Context, before time.Time) (int64, error) {
+	sqlStr := "delete from " + s.tableName + " where date(created_at) < date(?);"
+
+	result, err := s.conn.ExecContext(ctx, sqlStr, before)
+	if err != nil {
+		return 0, fmt.Errorf("error while purging records, %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// insertValues returns values of the record in the order of insertColumns.
+func insertValues(record *outbox.Record) ([]any, error) {
+	payload, err := record.Payload().MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		headers     sql.NullString
+		orderingKey sql.NullString
+		publishAt   sql.NullTime
+	)
+
+	if len(record.Headers()) > 0 {
+		b, err := json.Marshal(record.Headers())
+		if err != nil {
+			return nil, err
+		}
+
+		headers = sql.NullString{String: string(b), Valid: true}
+	}
+
+	if record.OrderingKey() != "" {
+		orderingKey = sql.NullString{String: record.OrderingKey(), Valid: true}
+	}
+
+	if !record.PublishAt().IsZero() {
+		publishAt = sql.NullTime{Time: record.PublishAt().UTC(), Valid: true}
+	}
+
+	return []any{
+		record.ID(),
+		record.EventType(),
+		string(payload),
+		headers,
+		orderingKey,
+		publishAt,
+	}, nil
+}
+
+// placeholders returns the list of n placeholders in parentheses.
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+func scanIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	ids := make([]string, 0)
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
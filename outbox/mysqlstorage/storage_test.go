@@ -0,0 +1,72 @@
+package mysqlstorage_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/mysqlstorage"
+	"github.com/Melenium2/go-iobox/outbox/storagetest"
+)
+
+type StorageSuite struct {
+	suite.Suite
+
+	db      *sql.DB
+	storage *mysqlstorage.Storage
+}
+
+func TestStorageSuite(t *testing.T) {
+	suite.Run(t, &StorageSuite{})
+}
+
+func (suite *StorageSuite) SetupSuite() {
+	db, err := sql.Open("mysql", "root:mysql@tcp(localhost:3307)/outbox?parseTime=true")
+	suite.Require().NoError(err)
+
+	err = db.Ping()
+	suite.Require().NoError(err)
+
+	suite.db = db
+	suite.storage = mysqlstorage.New(db)
+
+	err = suite.storage.InitOutboxTable(context.Background())
+	suite.Require().NoError(err)
+}
+
+func (suite *StorageSuite) TestStorage_Should_pass_conformance_tests() {
+	storagetest.Run(suite.T(), func(*testing.T) (outbox.Storage, outbox.Execer) {
+		_, err := suite.db.Exec("delete from __outbox_table;")
+		suite.Require().NoError(err)
+
+		return suite.storage, suite.db
+	})
+}
+
+func (suite *StorageSuite) TestInitOutboxTable_Should_create_table_with_custom_name() {
+	ctx := context.Background()
+
+	storage := mysqlstorage.New(suite.db, mysqlstorage.WithTableName("orders_outbox"))
+
+	err := storage.InitOutboxTable(ctx)
+	suite.Require().NoError(err)
+
+	defer func() {
+		_, _ = suite.db.Exec("drop table if exists orders_outbox, orders_outbox_schema;")
+	}()
+
+	record := outbox.NewRecord("1", "topic1", json.RawMessage("{}"))
+
+	err = storage.Insert(ctx, suite.db, record)
+	suite.Require().NoError(err)
+
+	records, err := storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().NoError(err)
+	suite.Assert().Len(records, 1)
+}
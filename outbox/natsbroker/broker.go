@@ -0,0 +1,91 @@
+// Package natsbroker implements outbox.Broker and outbox.MessageBroker
+// on top of NATS JetStream.
+//
+// The event ID is sent in the Nats-Msg-Id header, so events published
+// twice within the duplicates window of the stream are stored once.
+package natsbroker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/Melenium2/go-iobox/outbox"
+)
+
+// HeaderEventType is the header with the event type of the message.
+const HeaderEventType = "Event-Type"
+
+var (
+	_ outbox.Broker        = (*Broker)(nil)
+	_ outbox.MessageBroker = (*Broker)(nil)
+)
+
+// Publisher publishes messages to JetStream. jetstream.JetStream
+// implements the interface.
+type Publisher interface {
+	PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+}
+
+// Broker publishes outbox events to JetStream.
+type Broker struct {
+	js     Publisher
+	config config
+}
+
+// New creates new Broker publishing messages with the JetStream.
+func New(js Publisher, opts ...Option) *Broker {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return &Broker{
+		js:     js,
+		config: cfg,
+	}
+}
+
+// Publish publishes the payload with the event type subject.
+func (b *Broker) Publish(ctx context.Context, subject string, payload []byte) error {
+	return b.PublishMessage(ctx, outbox.Message{
+		EventType: subject,
+		Payload:   payload,
+	})
+}
+
+// PublishMessage publishes the message to the subject of its event type
+// and waits for the acknowledgement of the stream. The duplicate of the
+// message already stored in the stream is not an error.
+func (b *Broker) PublishMessage(ctx context.Context, msg outbox.Message) error {
+	subject := b.config.subject(msg.EventType)
+
+	if _, err := b.js.PublishMsg(ctx, b.message(subject, msg)); err != nil {
+		return fmt.Errorf("message not published to %q, %w", subject, err)
+	}
+
+	return nil
+}
+
+func (b *Broker) message(subject string, msg outbox.Message) *nats.Msg {
+	header := make(nats.Header, len(msg.Headers)+2)
+
+	for key, value := range msg.Headers {
+		header.Set(key, value)
+	}
+
+	header.Set(HeaderEventType, msg.EventType)
+
+	if msg.ID != "" {
+		header.Set(jetstream.MsgIDHeader, msg.ID)
+	}
+
+	return &nats.Msg{
+		Subject: subject,
+		Header:  header,
+		Data:    msg.Payload,
+	}
+}
@@ -0,0 +1,122 @@
+package natsbroker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/natsbroker"
+)
+
+// runJetStream starts embedded server and creates the orders stream.
+func runJetStream(t *testing.T) jetstream.JetStream {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+
+	go srv.Start()
+
+	require.True(t, srv.ReadyForConnections(5*time.Second))
+
+	conn, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Shutdown()
+	})
+
+	js, err := jetstream.New(conn)
+	require.NoError(t, err)
+
+	_, err = js.CreateStream(context.Background(), jetstream.StreamConfig{
+		Name:       "ORDERS",
+		Subjects:   []string{"orders.>"},
+		Duplicates: time.Minute,
+	})
+	require.NoError(t, err)
+
+	return js
+}
+
+func TestPublishMessage_Should_set_record_id_as_msg_id(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		js     = runJetStream(t)
+		broker = natsbroker.New(js, natsbroker.WithSubjectPrefix("orders"))
+	)
+
+	msg := outbox.Message{
+		ID:        "1",
+		EventType: "created",
+		Payload:   []byte("{}"),
+		Headers:   map[string]string{"trace-id": "2"},
+	}
+
+	err := broker.PublishMessage(ctx, msg)
+	require.NoError(t, err)
+
+	stream, err := js.Stream(ctx, "ORDERS")
+	require.NoError(t, err)
+
+	stored, err := stream.GetLastMsgForSubject(ctx, "orders.created")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("{}"), stored.Data)
+	assert.Equal(t, "1", stored.Header.Get(jetstream.MsgIDHeader))
+	assert.Equal(t, "created", stored.Header.Get(natsbroker.HeaderEventType))
+	assert.Equal(t, "2", stored.Header.Get("trace-id"))
+}
+
+func TestPublishMessage_Should_store_duplicate_once(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		js     = runJetStream(t)
+		broker = natsbroker.New(js)
+	)
+
+	msg := outbox.Message{
+		ID:        "1",
+		EventType: "orders.created",
+		Payload:   []byte("{}"),
+	}
+
+	err := broker.PublishMessage(ctx, msg)
+	require.NoError(t, err)
+
+	// The record is published again, e.g. the worker died before
+	// the status is updated.
+	err = broker.PublishMessage(ctx, msg)
+	require.NoError(t, err)
+
+	stream, err := js.Stream(ctx, "ORDERS")
+	require.NoError(t, err)
+
+	info, err := stream.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), info.State.Msgs)
+}
+
+func TestPublish_Should_return_error_if_subject_has_no_stream(t *testing.T) {
+	var (
+		js     = runJetStream(t)
+		broker = natsbroker.New(js)
+	)
+
+	err := broker.Publish(context.Background(), "unknown", []byte("{}"))
+	assert.ErrorIs(t, err, jetstream.ErrNoStreamResponse)
+}
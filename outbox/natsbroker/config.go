@@ -0,0 +1,45 @@
+package natsbroker
+
+// SubjectFunc maps the event type to the subject of the event.
+type SubjectFunc func(eventType string) string
+
+// defaultSubject publishes events to the subject named as event type.
+func defaultSubject(eventType string) string {
+	return eventType
+}
+
+type config struct {
+	subject SubjectFunc
+}
+
+func defaultConfig() config {
+	return config{
+		subject: defaultSubject,
+	}
+}
+
+// Option sets specific configuration to the Broker.
+type Option func(config) config
+
+// WithSubjectPrefix publishes events to the subject with the prefix
+// followed by the event type, e.g. "orders.order-created".
+func WithSubjectPrefix(prefix string) Option {
+	return func(c config) config {
+		c.subject = func(eventType string) string {
+			return prefix + "." + eventType
+		}
+
+		return c
+	}
+}
+
+// WithSubjectFunc sets custom mapping of the event type to the subject.
+func WithSubjectFunc(subject SubjectFunc) Option {
+	return func(c config) config {
+		if subject != nil {
+			c.subject = subject
+		}
+
+		return c
+	}
+}
@@ -5,12 +5,20 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/Melenium2/go-iobox/backoff"
+	"github.com/Melenium2/go-iobox/internal/lifecycle"
 	"github.com/Melenium2/go-iobox/retention"
 )
 
+// Broker publishes event payload to the subject.
+//
+// Use MessageBroker if event metadata is required for publishing.
 type Broker interface {
 	Publish(ctx context.Context, subject string, payload []byte) error
 }
@@ -27,47 +35,90 @@ type Broker interface {
 type Outbox struct {
 	config config
 
-	broker    Broker
-	storage   *defaultStorage
+	broker    MessageBroker
+	storage   Storage
+	backoff   *backoff.Backoff
 	retention *retention.Policy
+
+	lifecycle *lifecycle.Lifecycle
 }
 
 // NewOutbox creates new outbox implementation.
 func NewOutbox(broker Broker, conn *sql.DB, opts ...Option) *Outbox {
+	return NewMessageOutbox(newBrokerAdapter(broker), conn, opts...)
+}
+
+// NewMessageOutbox creates new outbox implementation which publishes
+// events as Message envelopes.
+func NewMessageOutbox(broker MessageBroker, conn *sql.DB, opts ...Option) *Outbox {
 	cfg := defaultConfig()
 
 	for _, opt := range opts {
 		cfg = opt(cfg)
 	}
 
+	storage := cfg.storage
+	if storage == nil {
+		storage = newStorage(conn, cfg)
+	}
+
 	return &Outbox{
 		broker:    broker,
-		storage:   newStorage(conn),
-		retention: retention.NewPolicy(conn, tableName, cfg.retention),
+		storage:   storage,
+		backoff:   backoff.NewBackoff(),
+		retention: retention.NewPurgerPolicy(storage, cfg.retention),
 		config:    cfg,
+		lifecycle: lifecycle.New(),
 	}
 }
 
 // Writer creates new Client to write outgoing events to the temporary table.
 func (o *Outbox) Writer() Client {
-	return newClient(o.storage)
+	return newClient(o.storage, o.config.notifyDSN != "")
 }
 
 // Start initialize outbox table and start worker process. Worker
-// is process that send outgoing messages to broker.
+// is process that send outgoing messages to broker. Closing the context
+// stops the worker without waiting for in-flight events, use Shutdown
+// to stop the worker gracefully.
 func (o *Outbox) Start(ctx context.Context) error {
 	if err := o.storage.InitOutboxTable(ctx); err != nil {
 		return fmt.Errorf("can not initialize outbox table, storage return err: %w", err)
 	}
 
-	go o.run(ctx)
-	go o.retention.Start(ctx)
+	// In-flight events are published with context that is not
+	// canceled with ctx, so they can be finished during shutdown.
+	inFlight := o.lifecycle.InFlight(ctx)
+
+	o.lifecycle.Go(func() {
+		o.run(ctx, inFlight)
+	})
+
+	// Erasing is canceled after shutdown deadline, so it does not
+	// delay Shutdown.
+	purge := o.lifecycle.Context(ctx)
+
+	o.lifecycle.Go(func() {
+		o.retention.Start(purge)
+	})
 
 	return nil
 }
 
-// run starts the publishing process.
-func (o *Outbox) run(ctx context.Context) {
+// Shutdown stops the worker gracefully. The worker stops fetching new
+// events and waits for in-flight events until the context is done. After
+// that the publishing is aborted and events that are not published are
+// released back to the outbox table, so they will be published by
+// another worker.
+func (o *Outbox) Shutdown(ctx context.Context) error {
+	o.retention.Stop()
+
+	return o.lifecycle.Shutdown(ctx)
+}
+
+// run starts the publishing process. Events are published with
+// inFlight context.
+func (o *Outbox) run(ctx, inFlight context.Context) {
 	var (
 		backoffConfig = backoff.Config{
 			Min: time.Second,
@@ -77,70 +128,301 @@ func (o *Outbox) run(ctx context.Context) {
 	)
 
 	ticker := backoff.NewTicker(bf, o.config.iterationRate, o.config.iterationSeed)
+	defer ticker.Stop()
+
+	// notifications is nil if LISTEN/NOTIFY is disabled or not
+	// supported by the storage, so the worker only polls the table.
+	var notifications <-chan *pq.Notification
+
+	if n, ok := o.storage.(notifier); ok && o.config.notifyDSN != "" {
+		listener := o.listen(n.NotifyChannel())
+		defer listener.Close()
+
+		notifications = listener.NotificationChannel()
+	}
 
 	for {
 		select {
 		case <-ticker.C:
-			if err := o.iteration(context.Background()); err != nil {
+			if err := o.iteration(inFlight); err != nil {
+				o.config.onError(err)
+			}
+		case <-notifications:
+			drainNotifications(notifications)
+
+			if err := o.iteration(inFlight); err != nil {
 				o.config.onError(err)
 			}
 		case <-ctx.Done():
 			return
+		case <-o.lifecycle.Stopped():
+			return
+		}
+	}
+}
+
+// listen opens a dedicated connection listening for notifications
+// sent by the Client.
+func (o *Outbox) listen(channel string) *pq.Listener {
+	listener := pq.NewListener(
+		o.config.notifyDSN,
+		time.Second,
+		time.Minute,
+		func(_ pq.ListenerEventType, err error) {
+			if err != nil {
+				o.config.onError(fmt.Errorf("outbox listener, %w", err))
+			}
+		},
+	)
+
+	// Listen blocks until the connection is established, so the
+	// worker polls the table in the meantime.
+	go func() {
+		if err := listener.Listen(channel); err != nil {
+			o.config.onError(fmt.Errorf("can not listen outbox notifications, %w", err))
+		}
+	}()
+
+	return listener
+}
+
+// drainNotifications skips pending notifications, because
+// one iteration publishes all events written before.
+func drainNotifications(notifications <-chan *pq.Notification) {
+	for {
+		select {
+		case <-notifications:
+		default:
+			return
 		}
 	}
 }
 
-// iteration tries to send events to the broker, if operation was successful
-// updates status in the outbox table.
+// iteration fetches events from the outbox table batch by batch and
+// sends them to the broker until the table is drained. If some event
+// of the batch is not published, the rest of the table waits for the
+// next iteration. Not published events are retried with backoff until
+// the max retry attempts is reached, then the event marks as Dead.
 func (o *Outbox) iteration(ctx context.Context) error {
-	records, err := o.storage.Fetch(ctx)
+	for !o.lifecycle.IsStopped() {
+		more, err := o.processBatch(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// processBatch tries to send the next batch of events to the broker, if
+// operation was successful updates status in the outbox table. Returns true
+// if the whole batch is published and the table may contain more events.
+// The batch can be smaller than the batch size even if the table is not
+// drained, because only one event per ordering key is fetched at once.
+func (o *Outbox) processBatch(ctx context.Context) (bool, error) {
+	records, err := o.storage.Fetch(ctx, time.Now().UTC())
 	if errors.Is(err, ErrNoRecrods) {
-		return nil
+		return false, nil
 	}
 
 	if err != nil {
-		return fmt.Errorf("records not fetched, %w", err)
+		return false, fmt.Errorf("records not fetched, %w", err)
+	}
+
+	published := o.publishBatch(ctx, records)
+
+	// Statuses are saved even if publishing is aborted.
+	if err := o.updateStatus(context.WithoutCancel(ctx), records); err != nil {
+		return false, err
+	}
+
+	return published == len(records), nil
+}
+
+// publishBatch publishes the records with the worker pool of the publish
+// concurrency size and returns the number of published records. Records
+// with the same ordering key are published by one worker in the order of
+// the batch.
+func (o *Outbox) publishBatch(ctx context.Context, records []*Record) int {
+	var (
+		queue     = lanes(records)
+		published atomic.Int64
+		workers   = min(o.config.concurrency, len(queue))
+	)
+
+	if workers <= 1 {
+		for _, lane := range queue {
+			published.Add(int64(o.publishLane(ctx, lane)))
+		}
+
+		return int(published.Load())
+	}
+
+	var (
+		wg   sync.WaitGroup
+		next = make(chan []*Record)
+	)
+
+	for range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for lane := range next {
+				published.Add(int64(o.publishLane(ctx, lane)))
+			}
+		}()
+	}
+
+	for _, lane := range queue {
+		next <- lane
 	}
 
+	close(next)
+	wg.Wait()
+
+	return int(published.Load())
+}
+
+// lanes groups the records which must be published sequentially. Records
+// with the same ordering key are placed in one lane in the order of the
+// batch, each record without the ordering key has its own lane.
+func lanes(records []*Record) [][]*Record {
+	var (
+		result = make([][]*Record, 0, len(records))
+		byKey  = make(map[string]int)
+	)
+
 	for _, record := range records {
-		record.Done()
+		if record.orderingKey == "" {
+			result = append(result, []*Record{record})
 
-		payload, err := record.payload.MarshalJSON()
-		if err != nil {
-			record.Fail()
+			continue
+		}
+
+		if idx, ok := byKey[record.orderingKey]; ok {
+			result[idx] = append(result[idx], record)
 
-			return fmt.Errorf("payload not marshaled, %w", err)
+			continue
 		}
 
-		if err := o.publish(ctx, record.eventType, payload); err != nil {
-			// If we can not publish the event during a connection issue
-			// or whatever, we set the current record status to Null.
-			// This means that the current record has not yet been published.
-			record.Null()
+		byKey[record.orderingKey] = len(result)
+		result = append(result, []*Record{record})
+	}
+
+	return result
+}
+
+// publishLane publishes the records one after another and returns the
+// number of published records. If the record is not published, the rest
+// of the lane is released to keep the order of the ordering key.
+func (o *Outbox) publishLane(ctx context.Context, lane []*Record) int {
+	published := 0
 
-			o.config.onError(err)
+	for i, record := range lane {
+		if ctx.Err() != nil {
+			// Publishing is aborted, release the rest of the lane.
+			release(lane[i:])
+
+			break
+		}
+
+		if !o.publishRecord(ctx, record) {
+			// The rest of the lane waits for the record to keep
+			// the order of the ordering key.
+			release(lane[i+1:])
+
+			break
 		}
+
+		published++
 	}
 
-	if err := o.updateStatus(ctx, records); err != nil {
-		return err
+	return published
+}
+
+func release(records []*Record) {
+	for _, record := range records {
+		record.Null()
 	}
+}
 
-	return nil
+// publishRecord sends the record to the broker and sets its status
+// depending on the result. Returns true if the record is published.
+func (o *Outbox) publishRecord(ctx context.Context, record *Record) bool {
+	record.Done()
+
+	payload, err := record.payload.MarshalJSON()
+	if err != nil {
+		err = fmt.Errorf("payload of event %q not marshaled, %w", record.id, err)
+
+		// function mutate record inside itself.
+		_ = o.failOrDead(record, err)
+
+		o.config.onError(err)
+
+		return false
+	}
+
+	if err := o.publish(ctx, record.message(payload)); err != nil {
+		if ctx.Err() != nil {
+			// Publishing is aborted by shutdown, it is
+			// not counted as failed attempt.
+			record.Null()
+
+			return false
+		}
+
+		// If we can not publish the event during a connection issue
+		// or whatever, we set the current record status to Failed.
+		// The record will be published again after backoff delay.
+		_ = o.failOrDead(record, err)
+
+		o.config.onError(err)
+
+		return false
+	}
+
+	return true
 }
 
-func (o *Outbox) publish(ctx context.Context, eventType string, payload []byte) error {
+func (o *Outbox) publish(ctx context.Context, msg Message) error {
 	ctx, cancel := context.WithTimeout(ctx, o.config.timeout)
 	defer cancel()
 
-	err := o.broker.Publish(ctx, eventType, payload)
+	err := o.broker.PublishMessage(ctx, msg)
 	if err != nil {
-		return fmt.Errorf("event %q not published, %w", eventType, err)
+		return fmt.Errorf("event %q not published, %w", msg.EventType, err)
 	}
 
 	return nil
 }
 
+func (o *Outbox) failOrDead(record *Record, err error) *Record {
+	record.Fail(err)
+
+	attempt := record.Attempt()
+
+	if attempt >= o.config.maxRetryAttempts {
+		record.Dead()
+
+		o.config.onDead(record.id, err.Error())
+
+		return record
+	}
+
+	dur := o.backoff.Next(attempt)
+
+	record.CalcNewDeadline(dur)
+
+	return record
+}
+
 func (o *Outbox) updateStatus(ctx context.Context, records []*Record) error {
 	var (
 		success = make([]*Record, 0)
@@ -153,7 +435,7 @@ func (o *Outbox) updateStatus(ctx context.Context, records []*Record) error {
 			success = append(success, record)
 		}
 
-		if record.status == Failed {
+		if record.status == Failed || record.status == Dead {
 			fail = append(fail, record)
 		}
 
@@ -166,7 +448,7 @@ func (o *Outbox) updateStatus(ctx context.Context, records []*Record) error {
 		return err
 	}
 
-	if err := o.storage.Update(ctx, fail); err != nil {
+	if err := o.storage.UpdateAttempts(ctx, fail); err != nil {
 		return err
 	}
 
@@ -0,0 +1,574 @@
+package outbox_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/storagetest"
+)
+
+// killBroker terminates the goroutine that publishes the event, so the
+// worker dies in the middle of the iteration.
+type killBroker struct{}
+
+func (b *killBroker) Publish(context.Context, string, []byte) error {
+	runtime.Goexit()
+
+	return nil
+}
+
+type countBroker struct {
+	published atomic.Int64
+}
+
+func (b *countBroker) Publish(context.Context, string, []byte) error {
+	b.published.Add(1)
+
+	return nil
+}
+
+func TestOutbox_FailOrDead(t *testing.T) {
+	svc := outbox.NewOutbox(&countBroker{}, nil)
+
+	t.Run("should fail next record", func(t *testing.T) {
+		input := outbox.RecordWithAttempt(0, outbox.Progress)
+
+		output := svc.FailOrDead(input, errors.New("err"))
+		assert.Equal(t, 1, output.Attempt())
+		assert.Equal(t, outbox.Failed, output.Status())
+	})
+
+	t.Run("should setup deadline for next attempt", func(t *testing.T) {
+		input := outbox.RecordWithAttempt(3, outbox.Failed)
+
+		output := svc.FailOrDead(input, errors.New("err"))
+		assert.Equal(t, 4, output.Attempt())
+		assert.Equal(t, outbox.Failed, output.Status())
+		assert.Greater(t, output.NextAttempt(), time.Now().UTC())
+	})
+
+	t.Run("should mark record as 'dead'", func(t *testing.T) {
+		var deadID string
+
+		svc := outbox.NewOutbox(&countBroker{}, nil, outbox.OnDeadCallback(func(eventID string, _ string) {
+			deadID = eventID
+		}))
+
+		input := outbox.RecordWithAttempt(4, outbox.Failed)
+
+		output := svc.FailOrDead(input, errors.New("err"))
+		assert.Equal(t, 5, output.Attempt())
+		assert.Equal(t, outbox.Dead, output.Status())
+		assert.Equal(t, outbox.ID1(), deadID)
+	})
+}
+
+func TestWithLeaseTimeout(t *testing.T) {
+	t.Run("should set lease timeout", func(t *testing.T) {
+		assert.Equal(t, time.Minute, outbox.LeaseTimeout(outbox.WithLeaseTimeout(time.Minute)))
+	})
+
+	t.Run("should ignore non-positive lease timeout", func(t *testing.T) {
+		assert.Equal(t, outbox.DefaultLeaseTimeout, outbox.LeaseTimeout(outbox.WithLeaseTimeout(0)))
+		assert.Equal(t, outbox.DefaultLeaseTimeout, outbox.LeaseTimeout(outbox.WithLeaseTimeout(-time.Minute)))
+	})
+}
+
+func TestOutbox_WithStorage(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		broker  = &countBroker{}
+		storage = storagetest.NewMemoryStorage()
+		svc     = outbox.NewOutbox(broker, nil, outbox.WithStorage(storage))
+		payload = outbox.PayloadMarshaler{Body: []byte("{}")}
+	)
+
+	err := svc.Writer().WriteOutbox(ctx, nil, outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+	require.NoError(t, err)
+
+	err = svc.Iteration()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), broker.published.Load())
+
+	_, err = storage.Fetch(ctx, time.Now().UTC())
+	assert.ErrorIs(t, err, outbox.ErrNoRecrods)
+}
+
+// slowBroker counts the max number of events published at the same time.
+type slowBroker struct {
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+	published   atomic.Int64
+	failed      string
+}
+
+func (b *slowBroker) Publish(_ context.Context, _ string, payload []byte) error {
+	curr := b.inFlight.Add(1)
+	defer b.inFlight.Add(-1)
+
+	for {
+		prev := b.maxInFlight.Load()
+		if curr <= prev || b.maxInFlight.CompareAndSwap(prev, curr) {
+			break
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if string(payload) == b.failed {
+		return errors.New("broker is down")
+	}
+
+	b.published.Add(1)
+
+	return nil
+}
+
+func TestOutbox_PublishBatch(t *testing.T) {
+	newRecords := func(n int, opts ...outbox.RecordOption) []*outbox.Record {
+		records := make([]*outbox.Record, 0, n)
+
+		for i := range n {
+			payload := outbox.PayloadMarshaler{Body: []byte(fmt.Sprintf(`{"n": %d}`, i))}
+
+			records = append(records, outbox.NewRecord(fmt.Sprint(i), "topic1", &payload, opts...))
+		}
+
+		return records
+	}
+
+	t.Run("should publish events one after another by default", func(t *testing.T) {
+		broker := &slowBroker{}
+		svc := outbox.NewOutbox(broker, nil)
+
+		published := svc.PublishBatch(newRecords(3))
+		assert.Equal(t, 3, published)
+		assert.Equal(t, int64(1), broker.maxInFlight.Load())
+	})
+
+	t.Run("should publish events concurrently", func(t *testing.T) {
+		broker := &slowBroker{}
+		svc := outbox.NewOutbox(broker, nil, outbox.WithPublishConcurrency(4))
+
+		records := newRecords(8)
+
+		published := svc.PublishBatch(records)
+		assert.Equal(t, 8, published)
+		assert.Equal(t, int64(8), broker.published.Load())
+		assert.Equal(t, int64(4), broker.maxInFlight.Load())
+
+		for _, record := range records {
+			assert.Equal(t, outbox.Done, record.Status())
+		}
+	})
+
+	t.Run("should publish events with the same ordering key one after another", func(t *testing.T) {
+		broker := &slowBroker{}
+		svc := outbox.NewOutbox(broker, nil, outbox.WithPublishConcurrency(4))
+
+		published := svc.PublishBatch(newRecords(4, outbox.WithOrderingKey("order-1")))
+		assert.Equal(t, 4, published)
+		assert.Equal(t, int64(1), broker.maxInFlight.Load())
+	})
+
+	t.Run("should release the rest of ordering key if event is not published", func(t *testing.T) {
+		broker := &slowBroker{failed: `{"n": 0}`}
+		svc := outbox.NewOutbox(broker, nil, outbox.WithPublishConcurrency(4))
+
+		var (
+			ordered = newRecords(2, outbox.WithOrderingKey("order-1"))
+			other   = outbox.NewRecord("other", "topic1", &outbox.PayloadMarshaler{Body: []byte("{}")})
+		)
+
+		published := svc.PublishBatch(append(ordered, other))
+		assert.Equal(t, 1, published)
+		assert.Equal(t, outbox.Failed, ordered[0].Status())
+		assert.Equal(t, outbox.Null, ordered[1].Status())
+		assert.Equal(t, outbox.Done, other.Status())
+	})
+}
+
+// blockBroker blocks publishing until release is closed or
+// the context is done.
+type blockBroker struct {
+	started  chan struct{}
+	once     sync.Once
+	release  chan struct{}
+	canceled atomic.Bool
+}
+
+func newBlockBroker() *blockBroker {
+	return &blockBroker{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+}
+
+func (b *blockBroker) Publish(ctx context.Context, _ string, _ []byte) error {
+	b.once.Do(func() {
+		close(b.started)
+	})
+
+	select {
+	case <-b.release:
+		return nil
+	case <-ctx.Done():
+		b.canceled.Store(true)
+
+		return ctx.Err()
+	}
+}
+
+// recordingStorage records statuses and attempts of the updated records.
+type recordingStorage struct {
+	*storagetest.MemoryStorage
+
+	mu      sync.Mutex
+	updates []outbox.RecordState
+}
+
+func newRecordingStorage() *recordingStorage {
+	return &recordingStorage{MemoryStorage: storagetest.NewMemoryStorage()}
+}
+
+func (s *recordingStorage) Update(ctx context.Context, records []*outbox.Record) error {
+	s.record(records)
+
+	return s.MemoryStorage.Update(ctx, records)
+}
+
+func (s *recordingStorage) UpdateAttempts(ctx context.Context, records []*outbox.Record) error {
+	s.record(records)
+
+	return s.MemoryStorage.UpdateAttempts(ctx, records)
+}
+
+func (s *recordingStorage) record(records []*outbox.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range records {
+		s.updates = append(s.updates, outbox.RecordState{
+			ID:      record.ID(),
+			Status:  record.Status(),
+			Attempt: record.Attempt(),
+		})
+	}
+}
+
+// last returns the last update of the record.
+func (s *recordingStorage) last(id string) (outbox.RecordState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n := len(s.updates) - 1; n >= 0; n-- {
+		if s.updates[n].ID == id {
+			return s.updates[n], true
+		}
+	}
+
+	return outbox.RecordState{}, false
+}
+
+func TestOutbox_Shutdown(t *testing.T) {
+	t.Run("should return immediately if outbox is not started", func(t *testing.T) {
+		svc := outbox.NewOutbox(&countBroker{}, nil)
+
+		err := svc.Shutdown(context.Background())
+		assert.NoError(t, err)
+	})
+
+	t.Run("should wait for in-flight event", func(t *testing.T) {
+		var (
+			broker  = newBlockBroker()
+			storage = newRecordingStorage()
+			svc     = outbox.NewOutbox(broker, nil, outbox.WithStorage(storage))
+			payload = outbox.PayloadMarshaler{Body: []byte("{}")}
+		)
+
+		err := svc.Writer().WriteOutbox(context.Background(), nil, outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+		require.NoError(t, err)
+
+		err = svc.Start(context.Background())
+		require.NoError(t, err)
+
+		<-broker.started
+
+		done := make(chan error)
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			done <- svc.Shutdown(ctx)
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("shutdown returned before the event is published")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(broker.release)
+
+		require.NoError(t, <-done)
+		assert.False(t, broker.canceled.Load())
+
+		state, ok := storage.last(outbox.ID1())
+		require.True(t, ok)
+		assert.Equal(t, outbox.Done, state.Status)
+	})
+
+	t.Run("should release not published events after deadline", func(t *testing.T) {
+		var (
+			broker  = newBlockBroker()
+			storage = newRecordingStorage()
+			svc     = outbox.NewOutbox(broker, nil, outbox.WithStorage(storage), outbox.WithPublishTimeout(time.Minute))
+			payload = outbox.PayloadMarshaler{Body: []byte("{}")}
+		)
+
+		err := svc.Writer().WriteOutbox(context.Background(), nil, outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+		require.NoError(t, err)
+
+		err = svc.Start(context.Background())
+		require.NoError(t, err)
+
+		<-broker.started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err = svc.Shutdown(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.True(t, broker.canceled.Load())
+
+		state, ok := storage.last(outbox.ID1())
+		require.True(t, ok)
+		assert.Equal(t, outbox.Null, state.Status)
+		assert.Equal(t, 0, state.Attempt)
+	})
+}
+
+type OutboxSuite struct {
+	suite.Suite
+
+	db      *sql.DB
+	address string
+}
+
+func TestOutboxSuite(t *testing.T) {
+	suite.Run(t, &OutboxSuite{})
+}
+
+func (suite *OutboxSuite) SetupSuite() {
+	var (
+		host     = "localhost"
+		port     = "5437"
+		user     = "postgres"
+		pass     = "postgres"
+		database = "outbox"
+		address  = fmt.Sprintf(
+			"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			host, port, user, pass, database,
+		)
+	)
+
+	db, err := sql.Open("postgres", address)
+	suite.Require().NoError(err)
+
+	err = db.Ping()
+	suite.Require().NoError(err)
+
+	suite.db = db
+	suite.address = address
+
+	err = outbox.NewStorage(db).InitOutboxTable(context.Background())
+	suite.Require().NoError(err)
+}
+
+func (suite *OutboxSuite) TearDownTest() {
+	truncateTable(suite.db)
+}
+
+func (suite *OutboxSuite) TestIteration_Should_publish_event_exactly_once_after_worker_was_killed() {
+	var (
+		ctx        = context.Background()
+		lease      = 100 * time.Millisecond
+		killed     = outbox.NewOutbox(&killBroker{}, suite.db, outbox.WithLeaseTimeout(lease))
+		broker     = &countBroker{}
+		recovering = outbox.NewOutbox(broker, suite.db, outbox.WithLeaseTimeout(lease))
+	)
+
+	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
+
+	err := killed.Writer().WriteOutbox(ctx, suite.db, outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+	suite.Require().NoError(err)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		_ = killed.Iteration()
+	}()
+
+	wg.Wait()
+
+	// The event is claimed by the killed worker and is not fetched
+	// until the lease expires.
+	err = recovering.Iteration()
+	suite.Require().NoError(err)
+	suite.Assert().Equal(int64(0), broker.published.Load())
+
+	time.Sleep(2 * lease)
+
+	err = recovering.Iteration()
+	suite.Require().NoError(err)
+	suite.Assert().Equal(int64(1), broker.published.Load())
+
+	time.Sleep(2 * lease)
+
+	err = recovering.Iteration()
+	suite.Require().NoError(err)
+	suite.Assert().Equal(int64(1), broker.published.Load())
+
+	{
+		var (
+			sqlStr   = "select status from __outbox_table where id = $1;"
+			expected = "done"
+			dest     string
+		)
+		_ = suite.db.QueryRow(sqlStr, outbox.ID1()).Scan(&dest)
+		suite.Assert().Equal(expected, dest)
+	}
+}
+
+func (suite *OutboxSuite) TestStart_Should_publish_event_after_notification_without_waiting_for_next_tick() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &countBroker{}
+
+	ob := outbox.NewOutbox(
+		broker,
+		suite.db,
+		outbox.WithIterationRate(time.Hour),
+		outbox.WithListenNotify(suite.address),
+	)
+
+	err := ob.Start(ctx)
+	suite.Require().NoError(err)
+
+	// Wait for the first iteration and the LISTEN connection.
+	time.Sleep(time.Second)
+
+	tx, err := suite.db.BeginTx(ctx, nil)
+	suite.Require().NoError(err)
+
+	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
+
+	err = ob.Writer().WriteOutbox(ctx, tx, outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+	suite.Require().NoError(err)
+
+	err = tx.Commit()
+	suite.Require().NoError(err)
+
+	suite.Assert().Eventually(func() bool {
+		return broker.published.Load() == 1
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func (suite *OutboxSuite) TestShutdown_Should_wait_for_in_flight_events() {
+	broker := newBlockBroker()
+
+	ob := outbox.NewOutbox(broker, suite.db)
+
+	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
+
+	err := ob.Writer().WriteOutbox(context.Background(), suite.db, outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+	suite.Require().NoError(err)
+
+	err = ob.Start(context.Background())
+	suite.Require().NoError(err)
+
+	<-broker.started
+
+	done := make(chan error)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		done <- ob.Shutdown(ctx)
+	}()
+
+	select {
+	case <-done:
+		suite.FailNow("shutdown returned before the event is published")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(broker.release)
+
+	suite.Require().NoError(<-done)
+	suite.Assert().False(broker.canceled.Load())
+
+	{
+		var (
+			sqlStr   = "select status from __outbox_table where id = $1;"
+			expected = "done"
+			dest     string
+		)
+		_ = suite.db.QueryRow(sqlStr, outbox.ID1()).Scan(&dest)
+		suite.Assert().Equal(expected, dest)
+	}
+}
+
+func (suite *OutboxSuite) TestShutdown_Should_release_not_published_events_after_deadline() {
+	broker := newBlockBroker()
+
+	ob := outbox.NewOutbox(broker, suite.db, outbox.WithPublishTimeout(time.Minute))
+
+	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
+
+	err := ob.Writer().WriteOutbox(context.Background(), suite.db, outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+	suite.Require().NoError(err)
+
+	err = ob.Start(context.Background())
+	suite.Require().NoError(err)
+
+	<-broker.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = ob.Shutdown(ctx)
+	suite.Require().ErrorIs(err, context.DeadlineExceeded)
+	suite.Assert().True(broker.canceled.Load())
+
+	{
+		var (
+			sqlStr      = "select status, attempt from __outbox_table where id = $1;"
+			destStatus  sql.NullString
+			destAttempt int
+		)
+		_ = suite.db.QueryRow(sqlStr, outbox.ID1()).Scan(&destStatus, &destAttempt)
+		suite.Assert().False(destStatus.Valid)
+		suite.Assert().Equal(0, destAttempt)
+	}
+}
@@ -0,0 +1,71 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// NewOutboxFromPool creates new outbox implementation which uses
+// connections of the pgx pool.
+func NewOutboxFromPool(broker Broker, pool *pgxpool.Pool, opts ...Option) *Outbox {
+	return NewOutbox(broker, stdlib.OpenDBFromPool(pool), opts...)
+}
+
+// NewMessageOutboxFromPool creates new outbox implementation which uses
+// connections of the pgx pool and publishes events as Message envelopes.
+func NewMessageOutboxFromPool(broker MessageBroker, pool *pgxpool.Pool, opts ...Option) *Outbox {
+	return NewMessageOutbox(broker, stdlib.OpenDBFromPool(pool), opts...)
+}
+
+// PgxQuerier is implemented by pgx.Tx, *pgx.Conn and *pgxpool.Pool.
+type PgxQuerier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// PgxExecer adapts pgx querier to Execer, so the records can be written
+// in the pgx transaction.
+//
+//	err := client.WriteOutbox(ctx, outbox.PgxExecer(tx), record)
+func PgxExecer(q PgxQuerier) Execer {
+	return &pgxExecer{q: q}
+}
+
+type pgxExecer struct {
+	q PgxQuerier
+}
+
+func (e *pgxExecer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	tag, err := e.q.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgxResult(tag), nil
+}
+
+func (e *pgxExecer) queryIDs(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := e.q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// pgxResult implements sql.Result over the pgx command tag.
+type pgxResult pgconn.CommandTag
+
+func (r pgxResult) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported by postgres")
+}
+
+func (r pgxResult) RowsAffected() (int64, error) {
+	return pgconn.CommandTag(r).RowsAffected(), nil
+}
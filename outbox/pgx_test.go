@@ -0,0 +1,112 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Melenium2/go-iobox/outbox"
+)
+
+type PgxSuite struct {
+	suite.Suite
+
+	pool *pgxpool.Pool
+}
+
+func (suite *PgxSuite) SetupSuite() {
+	var (
+		host     = "localhost"
+		port     = "5437"
+		user     = "postgres"
+		pass     = "postgres"
+		database = "outbox"
+		address  = fmt.Sprintf(
+			"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			host, port, user, pass, database,
+		)
+	)
+
+	pool, err := pgxpool.New(context.Background(), address)
+	suite.Require().NoError(err)
+
+	err = pool.Ping(context.Background())
+	suite.Require().NoError(err)
+
+	suite.pool = pool
+
+	err = outbox.NewStorage(stdlib.OpenDBFromPool(pool)).InitOutboxTable(context.Background())
+	suite.Require().NoError(err)
+}
+
+func (suite *PgxSuite) TearDownSuite() {
+	suite.pool.Close()
+}
+
+func (suite *PgxSuite) TearDownTest() {
+	_, _ = suite.pool.Exec(context.Background(), "truncate __outbox_table;")
+}
+
+func (suite *PgxSuite) TestWriteOutbox_Should_write_record_in_pgx_transaction() {
+	ctx := context.Background()
+
+	client := outbox.NewOutboxFromPool(&countBroker{}, suite.pool).Writer()
+
+	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
+
+	tx, err := suite.pool.Begin(ctx)
+	suite.Require().NoError(err)
+
+	err = client.WriteOutbox(ctx, outbox.PgxExecer(tx), outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+	suite.Require().NoError(err)
+
+	// The record is not visible before commit.
+	suite.Assert().Equal(0, suite.count())
+
+	err = tx.Commit(ctx)
+	suite.Require().NoError(err)
+
+	suite.Assert().Equal(1, suite.count())
+}
+
+func (suite *PgxSuite) TestWriteOutboxBatch_Should_return_duplicates_in_pgx_transaction() {
+	ctx := context.Background()
+
+	client := outbox.NewOutboxFromPool(&countBroker{}, suite.pool).Writer()
+
+	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
+
+	records := []*outbox.Record{
+		outbox.NewRecord(outbox.ID1(), "topic1", &payload),
+		outbox.NewRecord(outbox.ID1(), "topic1", &payload),
+		outbox.NewRecord(outbox.ID2(), "topic1", &payload),
+	}
+
+	tx, err := suite.pool.Begin(ctx)
+	suite.Require().NoError(err)
+
+	duplicates, err := client.WriteOutboxBatch(ctx, outbox.PgxExecer(tx), records)
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]string{outbox.ID1()}, duplicates)
+
+	err = tx.Commit(ctx)
+	suite.Require().NoError(err)
+
+	suite.Assert().Equal(2, suite.count())
+}
+
+func (suite *PgxSuite) count() int {
+	var count int
+
+	_ = suite.pool.QueryRow(context.Background(), "select count(*) from __outbox_table;").Scan(&count)
+
+	return count
+}
+
+func TestPgxSuite(t *testing.T) {
+	suite.Run(t, &PgxSuite{})
+}
@@ -2,6 +2,7 @@ package outbox
 
 import (
 	"encoding/json"
+	"time"
 )
 
 // Status defines current status of Record.
@@ -17,14 +18,66 @@ const (
 	Done Status = "done"
 	// Null means the current Record is not processed yet.
 	Null Status = ""
+	// Dead means the current Record can not be published.
+	Dead Status = "dead"
 )
 
+type attempt struct {
+	attempt     int
+	message     string
+	nextAttempt time.Time
+}
+
 // Record is event that should be processed by outbox worker.
 type Record struct {
-	id        string
-	eventType string
-	status    Status
-	payload   json.Marshaler
+	id          string
+	eventType   string
+	status      Status
+	payload     json.Marshaler
+	headers     map[string]string
+	orderingKey string
+	publishAt   time.Time
+	attempt     attempt
+	createdAt   time.Time
+}
+
+// RecordOption sets optional fields of the Record.
+type RecordOption func(*Record)
+
+// WithHeaders adds custom headers to the Record. Headers are
+// passed to the MessageBroker together with the payload.
+func WithHeaders(headers map[string]string) RecordOption {
+	return func(r *Record) {
+		if r.headers == nil {
+			r.headers = make(map[string]string, len(headers))
+		}
+
+		for k, v := range headers {
+			r.headers[k] = v
+		}
+	}
+}
+
+// WithOrderingKey sets the ordering key of the Record, for example the
+// id of an aggregate. Records with the same key are published strictly
+// in the order in which they were written to the outbox table. If the
+// record is not published, the next records with the same key wait until
+// the record is published or marked as Dead. Records with other keys
+// are published independently.
+func WithOrderingKey(key string) RecordOption {
+	return func(r *Record) {
+		r.orderingKey = key
+	}
+}
+
+// WithPublishAt sets the time before which the Record is not published.
+// Use it to schedule delayed events in the same transaction as the
+// business action, e.g. a reminder 30 minutes after signup. If the Record
+// has an ordering key, the next records with the same key also wait.
+func WithPublishAt(publishAt time.Time) RecordOption {
+	return func(r *Record) {
+		r.publishAt = publishAt.UTC()
+	}
 }
 
 // NewRecord creates new record that can be processed by outbox worker.
@@ -35,12 +88,54 @@ type Record struct {
 //			will ignore all duplicate ids. ID can container max 36 byte.
 //	eventType - is a topic to which event will be published.
 //	payload - the body to be published.
-func NewRecord(id string, eventType string, payload json.Marshaler) *Record {
-	return &Record{
+//	opts (optional) - additional fields of the record, e.g. headers.
+func NewRecord(id string, eventType string, payload json.Marshaler, opts ...RecordOption) *Record {
+	record := &Record{
 		id:        id,
 		eventType: eventType,
 		payload:   payload,
 	}
+
+	for _, opt := range opts {
+		opt(record)
+	}
+
+	return record
+}
+
+// RecordState is the stored state of the Record. Storage implementations
+// use it to restore fetched records with RestoreRecord.
+type RecordState struct {
+	ID           string
+	EventType    string
+	Status       Status
+	Payload      []byte
+	Headers      map[string]string
+	OrderingKey  string
+	PublishAt    time.Time
+	Attempt      int
+	ErrorMessage string
+	NextAttempt  time.Time
+	CreatedAt    time.Time
+}
+
+// RestoreRecord creates the Record from the stored state.
+func RestoreRecord(state RecordState) *Record {
+	return &Record{
+		id:          state.ID,
+		eventType:   state.EventType,
+		status:      state.Status,
+		payload:     &dtoPayload{Body: state.Payload},
+		headers:     state.Headers,
+		orderingKey: state.OrderingKey,
+		publishAt:   state.PublishAt,
+		attempt: attempt{
+			attempt:     state.Attempt,
+			message:     state.ErrorMessage,
+			nextAttempt: state.NextAttempt,
+		},
+		createdAt: state.CreatedAt,
+	}
 }
 
 func newFullRecord(
@@ -48,12 +143,22 @@ func newFullRecord(
 	status Status,
 	eventType string,
 	payload json.Marshaler,
+	headers map[string]string,
+	orderingKey string,
+	currAttempt int,
+	createdAt time.Time,
 ) *Record {
 	return &Record{
-		id:        id,
-		status:    status,
-		eventType: eventType,
-		payload:   payload,
+		id:          id,
+		status:      status,
+		eventType:   eventType,
+		payload:     payload,
+		headers:     headers,
+		orderingKey: orderingKey,
+		attempt: attempt{
+			attempt: currAttempt,
+		},
+		createdAt: createdAt,
 	}
 }
 
@@ -63,13 +168,96 @@ func (r *Record) Done() {
 	r.status = Done
 }
 
-// Fail sets Failed status to current Record. Status will be
-// ignored on first save to the outbox table.
-func (r *Record) Fail() {
+// Fail sets Failed status to current Record and counts the failed
+// attempt. Status will be ignored on first save to the outbox table.
+func (r *Record) Fail(err error) {
 	r.status = Failed
+
+	r.attempt.message = err.Error()
+	r.attempt.attempt++
+}
+
+// Dead sets Dead status to current Record.
+func (r *Record) Dead() {
+	r.status = Dead
 }
 
 // Null sets Null status to current Record.
 func (r *Record) Null() {
 	r.status = ""
 }
+
+// ID returns the unique id of current Record.
+func (r *Record) ID() string {
+	return r.id
+}
+
+// EventType returns the event type of current Record.
+func (r *Record) EventType() string {
+	return r.eventType
+}
+
+// Status returns the status of current Record.
+func (r *Record) Status() Status {
+	return r.status
+}
+
+// Payload returns the payload of current Record.
+func (r *Record) Payload() json.Marshaler {
+	return r.payload
+}
+
+// Headers returns custom headers of current Record.
+func (r *Record) Headers() map[string]string {
+	return r.headers
+}
+
+// OrderingKey returns the ordering key of current Record.
+func (r *Record) OrderingKey() string {
+	return r.orderingKey
+}
+
+// PublishAt returns the time before which current Record is not published.
+func (r *Record) PublishAt() time.Time {
+	return r.publishAt
+}
+
+// Attempt returns the number of failed attempts to publish current Record.
+func (r *Record) Attempt() int {
+	return r.attempt.attempt
+}
+
+// ErrorMessage returns the error of the last failed attempt.
+func (r *Record) ErrorMessage() string {
+	return r.attempt.message
+}
+
+// NextAttempt returns the time after which failed Record is published again.
+func (r *Record) NextAttempt() time.Time {
+	return r.attempt.nextAttempt
+}
+
+// CreatedAt returns the time when current Record is written to the storage.
+func (r *Record) CreatedAt() time.Time {
+	return r.createdAt
+}
+
+// CalcNewDeadline sets the time after which current Record
+// will be published again.
+func (r *Record) CalcNewDeadline(dur time.Duration) {
+	now := time.Now().UTC()
+	now = now.Add(dur)
+
+	r.attempt.nextAttempt = now
+}
+
+func (r *Record) message(payload []byte) Message {
+	return Message{
+		ID:          r.id,
+		EventType:   r.eventType,
+		Payload:     payload,
+		Headers:     r.headers,
+		OrderingKey: r.orderingKey,
+		CreatedAt:   r.createdAt,
+	}
+}
@@ -0,0 +1,20 @@
+create table if not exists {{.Table}} (
+    seq integer primary key autoincrement,
+    id text not null unique,
+    status text,
+    event_type text not null,
+    payload text not null,
+    headers text,
+    ordering_key text,
+    attempt integer not null default 0,
+    error_message text,
+    next_attempt text,
+    publish_at text,
+    created_at text not null,
+    updated_at text not null
+);
+
+create index if not exists {{.Table.Index "ordering_key_seq_idx"}} on {{.Table}} (ordering_key, seq)
+    where ordering_key is not null;
+
+create index if not exists {{.Table.Index "created_at_idx"}} on {{.Table}} (created_at, seq);
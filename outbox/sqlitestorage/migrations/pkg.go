@@ -0,0 +1,9 @@
+package migrations
+
+import "embed"
+
+// FS contains SQLite migrations templates of the outbox table, the table
+// name is substituted by migration.TemplateFS.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,521 @@
+// Package sqlitestorage implements outbox.Storage on top of SQLite with
+// the pure Go modernc.org/sqlite driver, so it does not require cgo or
+// a database server.
+//
+// SQLite allows only one writer at the same time. Each write of the
+// Storage is a single statement, so it acquires the write lock at once
+// and waits for other writers with the busy timeout of the connection.
+// Writes of the Storage are also serialized inside the process. Open the
+// database with the busy timeout and immediate transactions, so the
+// transactions of the application wait for the lock instead of failing
+// with SQLITE_BUSY error.
+//
+//	db, _ := sql.Open("sqlite", "file:agent.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+//
+//	ob := outbox.NewOutbox(broker, nil, outbox.WithStorage(sqlitestorage.New(db)))
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+
+	"github.com/Melenium2/go-iobox/migration"
+	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/sqlitestorage/migrations"
+)
+
+var _ outbox.Storage = (*Storage)(nil)
+
+// defaultMigrationsTable is the migrations table of the outbox
+// table with default name.
+const defaultMigrationsTable = "outbox_schema"
+
+// timeFormat is the format of the time columns. SQLite does not have
+// the time type, so the time is stored as text of the same length in
+// UTC, which is compared in the chronological order.
+const timeFormat = "2006-01-02 15:04:05.000000"
+
+const (
+	insertColumns      = "id, event_type, payload, headers, ordering_key, publish_at, created_at, updated_at"
+	insertColumnsCount = 8
+	// maxInsertBatch is the max number of records inserted by one
+	// statement. SQLite allows max 32766 parameters in the query.
+	maxInsertBatch = 4000
+)
+
+// queryer is implemented by outbox.Execer which can return rows,
+// like *sql.DB, *sql.Tx and *sql.Conn.
+type queryer interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}
+
+// Storage stores outbox records in the SQLite table.
+type Storage struct {
+	conn  *sql.DB
+	table migration.Table
+	// tableName is quoted name of the table.
+	tableName    string
+	batchSize    int
+	leaseTimeout time.Duration
+
+	// mu serializes writes of the Storage, SQLite allows
+	// only one writer.
+	mu sync.Mutex
+}
+
+// New creates new Storage. The table is created by InitOutboxTable.
+func New(conn *sql.DB, opts ...Option) *Storage {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	table := migration.Table{
+		Name:    cfg.tableName,
+		Dialect: migration.SQLite,
+	}
+
+	return &Storage{
+		conn:         conn,
+		table:        table,
+		tableName:    table.String(),
+		batchSize:    cfg.batchSize,
+		leaseTimeout: cfg.leaseTimeout,
+	}
+}
+
+func (s *Storage) InitOutboxTable(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	driver, err := sqlite.WithInstance(s.conn, &sqlite.Config{MigrationsTable: s.migrationsTable()})
+	if err != nil {
+		return fmt.Errorf("failed to setup outbox migrations, %w", err)
+	}
+
+	m := migration.New()
+
+	fsys := migration.TemplateFS(migrations.FS, s.table)
+
+	if err = m.SetupDriverFS(fsys, "sqlite", driver); err != nil {
+		return fmt.Errorf("failed to setup outbox migrations, %w", err)
+	}
+
+	err = m.Up()
+	if err == nil {
+		return nil
+	}
+
+	_ = m.Down()
+
+	return fmt.Errorf("failed to run migrations, %w", err)
+}
+
+// migrationsTable returns the name of the table with migrations
+// version of the outbox table.
+func (s *Storage) migrationsTable() string {
+	if s.table.Name == outbox.DefaultTableName {
+		return defaultMigrationsTable
+	}
+
+	return s.table.Name + "_schema"
+}
+
+// Fetch claims the next batch of records with a single statement, so
+// the records are claimed atomically under the write lock.
+func (s *Storage) Fetch(ctx context.Context, fetchTime time.Time) ([]*outbox.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = ?, " +
+		" 			updated_at = ? " +
+		" 		where id in ( " +
+		" 			select t.id from " + s.tableName + " t " +
+		" 			where " +
+		" 				(" +
+		" 					(t.status is null and (t.publish_at is null or t.publish_at <= ?)) or " +
+		" 					(t.status = 'failed' and t.next_attempt <= ?) or " +
+		" 					(t.status = 'progress' and t.updated_at <= ?) " +
+		" 				) and ( " +
+		" 					t.ordering_key is null or not exists ( " +
+		" 						select 1 from " + s.tableName + " p " +
+		" 						where p.ordering_key = t.ordering_key " +
+		" 							and p.seq < t.seq " +
+		" 							and (p.status is null or p.status in ('progress', 'failed')) " +
+		" 					) " +
+		" 				) " +
+		" 			order by t.created_at, t.seq " +
+		" 			limit ? " +
+		" 		) " +
+		" 		returning seq, id, status, event_type, payload, headers, ordering_key, attempt, created_at;"
+
+	var (
+		now           = formatTime(time.Now())
+		leaseDeadline = formatTime(fetchTime.Add(-s.leaseTimeout))
+		fetchAt       = formatTime(fetchTime)
+	)
+
+	rows, err := s.conn.QueryContext(
+		ctx, sqlStr, string(outbox.Progress), now, fetchAt, fetchAt, leaseDeadline, s.batchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	records, err := scanRecords(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching records, %w", err)
+	}
+
+	if len(records) == 0 {
+		return nil, outbox.ErrNoRecrods
+	}
+
+	return records, nil
+}
+
+// scanRecords scans the rows to the records sorted by creation time.
+func scanRecords(rows *sql.Rows) ([]*outbox.Record, error) {
+	defer rows.Close()
+
+	type scanned struct {
+		seq   int64
+		state outbox.RecordState
+	}
+
+	result := make([]scanned, 0)
+
+	for rows.Next() {
+		var (
+			curr        scanned
+			status      sql.NullString
+			headers     sql.NullString
+			orderingKey sql.NullString
+			payload     string
+			createdAt   string
+		)
+
+		err := rows.Scan(
+			&curr.seq, &curr.state.ID, &status, &curr.state.EventType, &payload,
+			&headers, &orderingKey, &curr.state.Attempt, &createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if headers.Valid {
+			if err = json.Unmarshal([]byte(headers.String), &curr.state.Headers); err != nil {
+				return nil, fmt.Errorf("headers of record %q not unmarshaled, %w", curr.state.ID, err)
+			}
+		}
+
+		if curr.state.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+
+		curr.state.Status = outbox.Status(status.String)
+		curr.state.Payload = []byte(payload)
+		curr.state.OrderingKey = orderingKey.String
+
+		result = append(result, curr)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The order of rows returned by `returning` is not defined.
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].state.CreatedAt.Equal(result[j].state.CreatedAt) {
+			return result[i].seq < result[j].seq
+		}
+
+		return result[i].state.CreatedAt.Before(result[j].state.CreatedAt)
+	})
+
+	records := make([]*outbox.Record, 0, len(result))
+
+	for _, curr := range result {
+		records = append(records, outbox.RestoreRecord(curr.state))
+	}
+
+	return records, nil
+}
+
+func (s *Storage) Update(ctx context.Context, records []*outbox.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var recordsStatus sql.NullString
+
+	if records[0].Status() != outbox.Null {
+		recordsStatus = sql.NullString{String: string(records[0].Status()), Valid: true}
+	}
+
+	args := make([]any, 0, len(records)+2)
+	args = append(args, recordsStatus, formatTime(time.Now()))
+
+	for _, record := range records {
+		args = append(args, record.ID())
+	}
+
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = ?, " +
+		" 			updated_at = ? " +
+		" 		where id in " + placeholders(len(records)) + ";"
+
+	_, err := s.conn.ExecContext(ctx, sqlStr, args...)
+
+	return err
+}
+
+// UpdateAttempts updates status of the provided records together with
+// the information about the last failed attempt.
+func (s *Storage) UpdateAttempts(ctx context.Context, records []*outbox.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = ?, " +
+		" 			attempt = ?, " +
+		" 			error_message = ?, " +
+		" 			next_attempt = ?, " +
+		" 			updated_at = ? " +
+		" 		where id = ?;"
+
+	for _, curr := range records {
+		var (
+			recordStatus    sql.NullString
+			errorMessage    sql.NullString
+			attemptDeadline sql.NullString
+		)
+
+		if curr.Status() != outbox.Null {
+			recordStatus = sql.NullString{String: string(curr.Status()), Valid: true}
+		}
+
+		if curr.ErrorMessage() != "" {
+			errorMessage = sql.NullString{String: curr.ErrorMessage(), Valid: true}
+		}
+
+		if !curr.NextAttempt().IsZero() {
+			attemptDeadline = sql.NullString{String: formatTime(curr.NextAttempt()), Valid: true}
+		}
+
+		_, err := s.conn.ExecContext(
+			ctx,
+			sqlStr,
+			recordStatus,
+			curr.Attempt(),
+			errorMessage,
+			attemptDeadline,
+			formatTime(time.Now()),
+			curr.ID(),
+		)
+		if err != nil {
+			return fmt.Errorf("error while updating records, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Insert writes the record with tx. The record is written in the
+// transaction of the application, so it is not serialized with the
+// writes of the Storage.
+func (s *Storage) Insert(ctx context.Context, tx outbox.Execer, record *outbox.Record) error {
+	values, err := insertValues(record, formatTime(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, s.insertSQL(1)+";", values...)
+
+	return err
+}
+
+// InsertBatch inserts records with a single multi-row statement and returns
+// ids of the ignored duplicates. Duplicates are returned only if tx can
+// query rows.
+func (s *Storage) InsertBatch(ctx context.Context, tx outbox.Execer, records []*outbox.Record) ([]string, error) {
+	q, ok := tx.(queryer)
+
+	duplicates := make([]string, 0)
+
+	for start := 0; start < len(records); start += maxInsertBatch {
+		end := min(start+maxInsertBatch, len(records))
+
+		curr, err := s.insertBatch(ctx, tx, q, records[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		duplicates = append(duplicates, curr...)
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	return duplicates, nil
+}
+
+func (s *Storage) insertBatch(
+	ctx context.Context, tx outbox.Execer, q queryer, records []*outbox.Record,
+) ([]string, error) {
+	var (
+		args = make([]any, 0, len(records)*insertColumnsCount)
+		now  = formatTime(time.Now())
+	)
+
+	for _, record := range records {
+		values, err := insertValues(record, now)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, values...)
+	}
+
+	sqlStr := s.insertSQL(len(records))
+
+	if q == nil {
+		_, err := tx.ExecContext(ctx, sqlStr+";", args...)
+
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, sqlStr+" returning id;", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	inserted := make(map[string]int, len(records))
+
+	for rows.Next() {
+		var id string
+
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		inserted[id]++
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	duplicates := make([]string, 0)
+
+	for _, record := range records {
+		if inserted[record.ID()] > 0 {
+			inserted[record.ID()]--
+
+			continue
+		}
+
+		duplicates = append(duplicates, record.ID())
+	}
+
+	return duplicates, nil
+}
+
+// insertSQL returns the statement which inserts n records and ignores
+// the records with existing id.
+func (s *Storage) insertSQL(n int) string {
+	rows := make([]string, n)
+
+	for i := range n {
+		rows[i] = placeholders(insertColumnsCount)
+	}
+
+	return "insert into " + s.tableName + " (" + insertColumns + ") " +
+		" values " + strings.Join(rows, ", ") +
+		" on conflict do nothing"
+}
+
+func (s *Storage) Purge(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sqlStr := "delete from " + s.tableName + " where date(created_at) < date(?);"
+
+	result, err := s.conn.ExecContext(ctx, sqlStr, formatTime(before))
+	if err != nil {
+		return 0, fmt.Errorf("error while purging records, %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// insertValues returns values of the record in the order of insertColumns.
+func insertValues(record *outbox.Record, now string) ([]any, error) {
+	payload, err := record.Payload().MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		headers     sql.NullString
+		orderingKey sql.NullString
+		publishAt   sql.NullString
+	)
+
+	if len(record.Headers()) > 0 {
+		b, err := json.Marshal(record.Headers())
+		if err != nil {
+			return nil, err
+		}
+
+		headers = sql.NullString{String: string(b), Valid: true}
+	}
+
+	if record.OrderingKey() != "" {
+		orderingKey = sql.NullString{String: record.OrderingKey(), Valid: true}
+	}
+
+	if !record.PublishAt().IsZero() {
+		publishAt = sql.NullString{String: formatTime(record.PublishAt()), Valid: true}
+	}
+
+	return []any{
+		record.ID(),
+		record.EventType(),
+		string(payload),
+		headers,
+		orderingKey,
+		publishAt,
+		now,
+		now,
+	}, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.ParseInLocation(timeFormat, s, time.UTC)
+}
+
+// placeholders returns the list of n placeholders in parentheses.
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
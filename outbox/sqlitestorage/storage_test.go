@@ -0,0 +1,221 @@
+package sqlitestorage_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/sqlitestorage"
+	"github.com/Melenium2/go-iobox/outbox/storagetest"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "outbox.db") +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+	db, err := sql.Open("sqlite", dsn)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+func newStorage(t *testing.T, db *sql.DB, opts ...sqlitestorage.Option) *sqlitestorage.Storage {
+	t.Helper()
+
+	storage := sqlitestorage.New(db, opts...)
+
+	err := storage.InitOutboxTable(context.Background())
+	require.NoError(t, err)
+
+	return storage
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (outbox.Storage, outbox.Execer) {
+		db := openDB(t)
+
+		return newStorage(t, db), db
+	})
+}
+
+func TestStorage_InitOutboxTable(t *testing.T) {
+	t.Run("should create table with custom name", func(t *testing.T) {
+		var (
+			ctx     = context.Background()
+			db      = openDB(t)
+			storage = newStorage(t, db, sqlitestorage.WithTableName("orders_outbox"))
+		)
+
+		err := storage.Insert(ctx, db, outbox.NewRecord("1", "topic1", json.RawMessage("{}")))
+		require.NoError(t, err)
+
+		var count int
+
+		err = db.QueryRow("select count(*) from orders_outbox;").Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("should not fail if table is already created", func(t *testing.T) {
+		db := openDB(t)
+
+		newStorage(t, db)
+		newStorage(t, db)
+	})
+}
+
+func TestStorage_Fetch(t *testing.T) {
+	t.Run("should claim each record once by concurrent workers", func(t *testing.T) {
+		var (
+			ctx     = context.Background()
+			db      = openDB(t)
+			storage = newStorage(t, db, sqlitestorage.WithBatchSize(10))
+			workers = 4
+			total   = 200
+		)
+
+		records := make([]*outbox.Record, 0, total)
+
+		for i := range total {
+			records = append(records, outbox.NewRecord(strconv.Itoa(i), "topic1", json.RawMessage("{}")))
+		}
+
+		_, err := storage.InsertBatch(ctx, db, records)
+		require.NoError(t, err)
+
+		var (
+			mu      sync.Mutex
+			claimed = make(map[string]int, total)
+			wg      sync.WaitGroup
+		)
+
+		for range workers {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				// Each worker uses its own Storage, so the writes are
+				// serialized only by SQLite.
+				worker := sqlitestorage.New(db, sqlitestorage.WithBatchSize(10))
+
+				for {
+					batch, err := worker.Fetch(ctx, time.Now().UTC())
+					if err != nil {
+						assert.ErrorIs(t, err, outbox.ErrNoRecrods)
+
+						return
+					}
+
+					for _, record := range batch {
+						record.Done()
+					}
+
+					assert.NoError(t, worker.Update(ctx, batch))
+
+					mu.Lock()
+
+					for _, record := range batch {
+						claimed[record.ID()]++
+					}
+
+					mu.Unlock()
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		assert.Len(t, claimed, total)
+
+		for id, count := range claimed {
+			assert.Equal(t, 1, count, id)
+		}
+	})
+}
+
+func TestStorage_Insert(t *testing.T) {
+	// timeout is less than the busy timeout of the connection, so
+	// the statement waiting for the lock is caught before it fails.
+	const timeout = 3 * time.Second
+
+	interleave := func(t *testing.T, db *sql.DB) {
+		t.Helper()
+
+		var (
+			ctx     = context.Background()
+			storage = newStorage(t, db)
+		)
+
+		tx, err := db.BeginTx(ctx, nil)
+		require.NoError(t, err)
+
+		err = storage.Insert(ctx, tx, outbox.NewRecord("1", "topic1", json.RawMessage("{}")))
+		require.NoError(t, err)
+
+		fetched := make(chan error, 1)
+
+		go func() {
+			_, err := storage.Fetch(ctx, time.Now().UTC())
+			fetched <- err
+		}()
+
+		inserted := make(chan error, 1)
+
+		go func() {
+			// Fetch is waiting for the write lock or the connection
+			// held by the transaction.
+			time.Sleep(50 * time.Millisecond)
+
+			_, err := storage.InsertBatch(ctx, tx, []*outbox.Record{
+				outbox.NewRecord("2", "topic1", json.RawMessage("{}")),
+			})
+			if err == nil {
+				err = tx.Commit()
+			}
+
+			inserted <- err
+		}()
+
+		select {
+		case err = <-inserted:
+			require.NoError(t, err)
+		case <-time.After(timeout):
+			t.Fatal("insert in transaction is blocked by fetch")
+		}
+
+		select {
+		case err = <-fetched:
+			require.NoError(t, err)
+		case <-time.After(timeout):
+			t.Fatal("fetch is blocked by transaction")
+		}
+	}
+
+	t.Run("should not block fetch and insert in open transaction", func(t *testing.T) {
+		interleave(t, openDB(t))
+	})
+
+	t.Run("should not block fetch and insert in open transaction with single connection", func(t *testing.T) {
+		db := openDB(t)
+		db.SetMaxOpenConns(1)
+
+		interleave(t, db)
+	})
+}
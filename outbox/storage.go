@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -12,22 +14,95 @@ import (
 	"github.com/Melenium2/go-iobox/outbox/migrations"
 )
 
-const tableName = "__outbox_table"
+// Storage stores outbox records. The default implementation stores
+// records in the Postgres table, use WithStorage to replace it. Run
+// storagetest.Run to check that the implementation meets the contract.
+type Storage interface {
+	// InitOutboxTable prepares the storage, e.g. runs migrations.
+	InitOutboxTable(ctx context.Context) error
+	// Fetch claims records which are ready to be published at fetchTime
+	// and sets Progress status to them. Claimed records are not returned
+	// by the next calls until their status is updated or the lease is
+	// expired. Only the oldest not published record of each ordering key
+	// is returned. Records are sorted by creation time. Returns
+	// ErrNoRecrods if there are no records to publish.
+	Fetch(ctx context.Context, fetchTime time.Time) ([]*Record, error)
+	// Update saves statuses of the records.
+	Update(ctx context.Context, records []*Record) error
+	// UpdateAttempts saves statuses and failed attempts of the records.
+	UpdateAttempts(ctx context.Context, records []*Record) error
+	// Insert writes the record with tx. Records with existing id
+	// are ignored.
+	Insert(ctx context.Context, tx Execer, record *Record) error
+	// InsertBatch writes the records with tx and returns ids of
+	// the ignored duplicates.
+	InsertBatch(ctx context.Context, tx Execer, records []*Record) ([]string, error)
+	// Purge deletes records created before the date and returns
+	// the number of deleted records.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// notifier is implemented by Storage which can wake up the worker
+// with LISTEN/NOTIFY.
+type notifier interface {
+	Notify(ctx context.Context, tx Execer) error
+	NotifyChannel() string
+}
+
+// NewPostgresStorage creates the default Storage which stores records in
+// the Postgres table. Only options of the table and fetching are applied.
+func NewPostgresStorage(conn *sql.DB, opts ...Option) Storage {
+	cfg := defaultConfig()
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return newStorage(conn, cfg)
+}
+
+// defaultMigrationsTable is the migrations table of the outbox
+// table with default name.
+const defaultMigrationsTable = "outbox_schema"
 
 type defaultStorage struct {
-	conn *sql.DB
+	conn  *sql.DB
+	table migration.Table
+	// tableName is quoted and schema qualified name of the table.
+	tableName    string
+	batchSize    int
+	leaseTimeout time.Duration
 }
 
-func newStorage(conn *sql.DB) *defaultStorage {
+func newStorage(conn *sql.DB, cfg config) *defaultStorage {
+	table := migration.Table{
+		Schema: cfg.schema,
+		Name:   cfg.tableName,
+	}
+
 	return &defaultStorage{
-		conn: conn,
+		conn:         conn,
+		table:        table,
+		tableName:    table.String(),
+		batchSize:    cfg.batchSize,
+		leaseTimeout: cfg.leaseTimeout,
 	}
 }
 
 func (s *defaultStorage) InitOutboxTable(ctx context.Context) error {
-	m := migration.New()
+	if s.table.Schema != "" {
+		sqlStr := "create schema if not exists " + pq.QuoteIdentifier(s.table.Schema) + ";"
+
+		if _, err := s.conn.ExecContext(ctx, sqlStr); err != nil {
+			return fmt.Errorf("failed to create outbox schema, %w", err)
+		}
+	}
+
+	m := migration.New(migration.WithSchema(s.table.Schema))
 
-	if err := m.SetupFS(ctx, s.conn, migrations.FS, "outbox_schema"); err != nil {
+	fsys := migration.TemplateFS(migrations.FS, s.table)
+
+	if err := m.SetupFS(ctx, s.conn, fsys, s.migrationsTable()); err != nil {
 		return fmt.Errorf("failed to setup outbox migrations, %w", err)
 	}
 
@@ -41,16 +116,65 @@ func (s *defaultStorage) InitOutboxTable(ctx context.Context) error {
 	return fmt.Errorf("failed to run migrations, %w", err)
 }
 
-func (s *defaultStorage) Fetch(ctx context.Context) ([]*Record, error) {
-	dest := make([]*dtoRecord, 0)
+// migrationsTable returns the name of the table with migrations
+// version of the outbox table.
+func (s *defaultStorage) migrationsTable() string {
+	if s.table.Name == DefaultTableName {
+		return defaultMigrationsTable
+	}
+
+	return s.table.Name + "_schema"
+}
+
+// NotifyChannel returns the channel used to wake up the outbox worker.
+func (s *defaultStorage) NotifyChannel() string {
+	if s.table.Schema == "" {
+		return s.table.Name
+	}
+
+	return s.table.Schema + "." + s.table.Name
+}
 
-	sqlStr := "update " + tableName + " set " +
+// Fetch claims the next batch of unprocessed records which publish time
+// has come and failed records which next attempt time has come. Records that stay in 'progress'
+// status longer than the lease timeout are claimed again.
+// Rows locked by another worker are skipped, so several workers can fetch
+// records from the same table concurrently.
+//
+// A record with an ordering key is claimed only if all previous records
+// with the same key are done or dead. So at most one record per ordering
+// key is claimed at the same time.
+func (s *defaultStorage) Fetch(ctx context.Context, fetchTime time.Time) ([]*Record, error) {
+	dest := make([]*dtoRecord, 0, s.batchSize)
+
+	sqlStr := "update " + s.tableName + " set " +
 		" 				status = $1," +
 		" 				updated_at = (now() at time zone 'utc') " +
-		" 		where status is null " +
-		" 		returning id, status, event_type, payload, created_at;"
-
-	if err := s.selectRows(ctx, s.conn, &dest, sqlStr, Progress); err != nil {
+		" 		where id in ( " +
+		" 			select t.id from " + s.tableName + " t " +
+		" 			where " +
+		" 				(" +
+		" 					(t.status is null and (t.publish_at is null or t.publish_at <= $2)) or " +
+		" 					(t.status = 'failed' and t.next_attempt <= $2) or " +
+		" 					(t.status = 'progress' and t.updated_at <= $3) " +
+		" 				) and ( " +
+		" 					t.ordering_key is null or not exists ( " +
+		" 						select 1 from " + s.tableName + " p " +
+		" 						where p.ordering_key = t.ordering_key " +
+		" 							and p.seq < t.seq " +
+		" 							and (p.status is null or p.status in ('progress', 'failed')) " +
+		" 					) " +
+		" 				) " +
+		" 			order by t.created_at, t.seq " +
+		" 			limit $4 " +
+		" 			for update of t skip locked " +
+		" 		) " +
+		" 		returning id, status, event_type, payload, headers, ordering_key, attempt, created_at;"
+
+	leaseDeadline := fetchTime.Add(-s.leaseTimeout)
+
+	err := s.selectRows(ctx, s.conn, &dest, sqlStr, Progress, fetchTime, leaseDeadline, s.batchSize)
+	if err != nil {
 		return nil, err
 	}
 
@@ -67,7 +191,7 @@ func (s *defaultStorage) Update(ctx context.Context, records []*Record) error {
 	}
 
 	var (
-		sqlStr = "update " + tableName + " set " +
+		sqlStr = "update " + s.tableName + " set " +
 			" 			status = $1, " +
 			"			updated_at = (now() at time zone 'utc') " +
 			" 		where id = any ($2);"
@@ -90,20 +214,218 @@ func (s *defaultStorage) Update(ctx context.Context, records []*Record) error {
 	return err
 }
 
+// UpdateAttempts updates status of the provided records together with
+// the information about the last failed attempt.
+func (s *defaultStorage) UpdateAttempts(ctx context.Context, records []*Record) error {
+	sqlStr := "update " + s.tableName + " set " +
+		" 			status = $1, " +
+		" 			attempt = $2, " +
+		" 			error_message = $3, " +
+		" 			next_attempt = $4, " +
+		"			updated_at = (now() at time zone 'utc') " +
+		" 		where id = $5;"
+
+	for _, curr := range records {
+		var (
+			recordStatus    sql.NullString
+			errorMessage    sql.NullString
+			attemptDeadline sql.NullTime
+		)
+
+		if curr.status != "" {
+			recordStatus = sql.NullString{String: string(curr.status), Valid: true}
+		}
+
+		if curr.attempt.message != "" {
+			errorMessage = sql.NullString{String: curr.attempt.message, Valid: true}
+		}
+
+		if !curr.attempt.nextAttempt.IsZero() {
+			attemptDeadline = sql.NullTime{Time: curr.attempt.nextAttempt, Valid: true}
+		}
+
+		_, err := s.conn.ExecContext(
+			ctx,
+			sqlStr,
+			recordStatus,
+			curr.attempt.attempt,
+			errorMessage,
+			attemptDeadline,
+			curr.id,
+		)
+		if err != nil {
+			return fmt.Errorf("error while updating records, %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (s *defaultStorage) Insert(ctx context.Context, tx Execer, record *Record) error {
-	sqlStr := "insert into " + tableName + " (id, event_type, payload) values ($1, $2, $3) " +
-		" on conflict do nothing;"
+	sqlStr := "insert into " + s.tableName + " (" + insertColumns + ") " +
+		" values " + insertPlaceholders(0) + " on conflict do nothing;"
 
-	payload, err := record.payload.MarshalJSON()
+	values, err := insertValues(record)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, sqlStr, record.id, record.eventType, string(payload))
+	_, err = tx.ExecContext(ctx, sqlStr, values...)
+
+	return err
+}
+
+// InsertBatch inserts records with a single multi-row statement and returns
+// ids of the ignored duplicates. Duplicates are returned only if tx can
+// query rows. Batches larger than maxInsertBatch are split into several
+// statements because of the limit of query parameters.
+func (s *defaultStorage) InsertBatch(ctx context.Context, tx Execer, records []*Record) ([]string, error) {
+	duplicates := make([]string, 0)
+
+	for start := 0; start < len(records); start += maxInsertBatch {
+		end := min(start+maxInsertBatch, len(records))
+
+		curr, err := s.insertBatch(ctx, tx, records[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		duplicates = append(duplicates, curr...)
+	}
+
+	if _, ok := asIDsQueryer(tx); !ok {
+		return nil, nil
+	}
+
+	return duplicates, nil
+}
+
+func (s *defaultStorage) insertBatch(ctx context.Context, tx Execer, records []*Record) ([]string, error) {
+	var (
+		placeholders = make([]string, 0, len(records))
+		args         = make([]any, 0, len(records)*insertColumnsCount)
+	)
+
+	for i, record := range records {
+		values, err := insertValues(record)
+		if err != nil {
+			return nil, err
+		}
+
+		placeholders = append(placeholders, insertPlaceholders(i*insertColumnsCount))
+		args = append(args, values...)
+	}
+
+	sqlStr := "insert into " + s.tableName + " (" + insertColumns + ") " +
+		" values " + strings.Join(placeholders, ", ") + " on conflict do nothing"
+
+	q, ok := asIDsQueryer(tx)
+	if !ok {
+		_, err := tx.ExecContext(ctx, sqlStr+";", args...)
+
+		return nil, err
+	}
+
+	ids, err := q.queryIDs(ctx, sqlStr+" returning id;", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted := make(map[string]int, len(records))
+
+	for _, id := range ids {
+		inserted[id]++
+	}
+
+	duplicates := make([]string, 0)
+
+	for _, record := range records {
+		if inserted[record.id] > 0 {
+			inserted[record.id]--
+
+			continue
+		}
+
+		duplicates = append(duplicates, record.id)
+	}
+
+	return duplicates, nil
+}
+
+func (s *defaultStorage) Purge(ctx context.Context, before time.Time) (int64, error) {
+	sqlStr := "delete from " + s.tableName + " where created_at::date < $1::date;"
+
+	result, err := s.conn.ExecContext(ctx, sqlStr, before)
+	if err != nil {
+		return 0, fmt.Errorf("error while purging records, %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Notify sends notification to the outbox worker. Notification
+// is delivered only after the transaction is committed.
+func (s *defaultStorage) Notify(ctx context.Context, tx Execer) error {
+	_, err := tx.ExecContext(ctx, "select pg_notify($1, '');", s.NotifyChannel())
 
 	return err
 }
 
+const (
+	insertColumns      = "id, event_type, payload, headers, ordering_key, publish_at"
+	insertColumnsCount = 6
+	// maxInsertBatch is the max number of records inserted by one
+	// statement. Postgres allows max 65535 parameters in the query.
+	maxInsertBatch = 10000
+)
+
+// insertPlaceholders returns placeholders of the inserted row
+// which parameters start after offset.
+func insertPlaceholders(offset int) string {
+	placeholders := make([]string, insertColumnsCount)
+
+	for i := range insertColumnsCount {
+		placeholders[i] = "$" + strconv.Itoa(offset+i+1)
+	}
+
+	return "(" + strings.Join(placeholders, ", ") + ")"
+}
+
+// insertValues returns values of the record in the order of insertColumns.
+func insertValues(record *Record) ([]any, error) {
+	payload, err := record.payload.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := makeHeaders(record.headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		orderingKey sql.NullString
+		publishAt   sql.NullTime
+	)
+
+	if record.orderingKey != "" {
+		orderingKey = sql.NullString{String: record.orderingKey, Valid: true}
+	}
+
+	if !record.publishAt.IsZero() {
+		publishAt = sql.NullTime{Time: record.publishAt, Valid: true}
+	}
+
+	return []any{
+		record.id,
+		record.eventType,
+		string(payload),
+		headers,
+		orderingKey,
+		publishAt,
+	}, nil
+}
+
 func (s *defaultStorage) selectRows(ctx context.Context, conn *sql.DB, dest *[]*dtoRecord, sqlStr string, args ...any) error {
 	rows, err := conn.QueryContext(ctx, sqlStr, args...)
 	if err != nil {
@@ -113,19 +435,24 @@ func (s *defaultStorage) selectRows(ctx context.Context, conn *sql.DB, dest *[]*
 	defer rows.Close()
 
 	var (
-		id        string
-		status    sql.NullString
-		eventType string
-		payload   []byte
-		createdAt time.Time
+		id          string
+		status      sql.NullString
+		eventType   string
+		payload     []byte
+		headers     []byte
+		orderingKey sql.NullString
+		attempt     int
+		createdAt   time.Time
 	)
 	for rows.Next() {
-		err = rows.Scan(&id, &status, &eventType, &payload, &createdAt)
+		err = rows.Scan(&id, &status, &eventType, &payload, &headers, &orderingKey, &attempt, &createdAt)
 		if err != nil {
 			return err
 		}
 
-		*dest = append(*dest, newDtoRecord(id, status.String, eventType, payload, createdAt))
+		dto := newDtoRecord(id, status.String, eventType, payload, headers, orderingKey.String, attempt, createdAt)
+
+		*dest = append(*dest, dto)
 	}
 
 	return nil
@@ -3,20 +3,24 @@ package outbox_test
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/storagetest"
 )
 
 type StorageSuite struct {
 	suite.Suite
 
 	db      *sql.DB
-	storage *outbox.Storage
+	storage *outbox.PostgresStorage
 }
 
 func (suite *StorageSuite) SetupSuite() {
@@ -62,7 +66,7 @@ func (suite *StorageSuite) TestFetch_Should_fetch_unprocessed_rows_and_set_new_s
 		outbox.Record2(),
 	}
 
-	result, err := suite.storage.Fetch(ctx)
+	result, err := suite.storage.Fetch(ctx, time.Now().UTC())
 	suite.Require().NoError(err)
 	suite.Assert().Equal(expected, result)
 }
@@ -72,7 +76,7 @@ func (suite *StorageSuite) TestFetch_Should_no_fetch_rows_because_table_are_empt
 
 	truncateTable(suite.db)
 
-	_, err := suite.storage.Fetch(ctx)
+	_, err := suite.storage.Fetch(ctx, time.Now().UTC())
 	suite.Require().ErrorIs(err, outbox.ErrNoRecrods)
 }
 
@@ -81,26 +85,129 @@ func (suite *StorageSuite) TestFetch_Should_no_fetch_rows_because_they_are_locke
 
 	ctx := context.Background()
 
-	_, err := suite.storage.Fetch(ctx)
+	_, err := suite.storage.Fetch(ctx, time.Now().UTC())
 	suite.Require().ErrorIs(err, outbox.ErrNoRecrods)
 }
 
+func (suite *StorageSuite) TestFetch_Should_fetch_rows_in_progress_longer_than_lease_timeout() {
+	initInProgressRows(suite.db)
+
+	ctx := context.Background()
+
+	expected := []*outbox.Record{
+		outbox.Record1(),
+		outbox.Record2(),
+	}
+
+	fetchTime := time.Now().UTC().Add(outbox.DefaultLeaseTimeout + time.Minute)
+
+	result, err := suite.storage.Fetch(ctx, fetchTime)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(expected, result)
+}
+
 func (suite *StorageSuite) TestFetch_Should_no_fetch_rows_if_all_rows_already_processed() {
 	initDoneRows(suite.db)
 
 	ctx := context.Background()
 
-	_, err := suite.storage.Fetch(ctx)
+	_, err := suite.storage.Fetch(ctx, time.Now().UTC())
 	suite.Require().ErrorIs(err, outbox.ErrNoRecrods)
 }
 
+func (suite *StorageSuite) TestFetch_Should_fetch_no_more_rows_than_batch_size() {
+	initNotProcessedRows(suite.db)
+
+	ctx := context.Background()
+
+	storage := outbox.NewStorage(suite.db, outbox.WithBatchSize(1))
+
+	expected := []*outbox.Record{
+		outbox.Record1(),
+	}
+
+	result, err := storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().NoError(err)
+	suite.Assert().Equal(expected, result)
+}
+
+func (suite *StorageSuite) TestFetch_Should_skip_rows_locked_by_another_transaction() {
+	initNotProcessedRows(suite.db)
+
+	ctx := context.Background()
+
+	tx, err := suite.db.BeginTx(ctx, nil)
+	suite.Require().NoError(err)
+
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, "select id from __outbox_table where id = $1 for update;", outbox.ID1())
+	suite.Require().NoError(err)
+
+	expected := []*outbox.Record{
+		outbox.Record2(),
+	}
+
+	result, err := suite.storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().NoError(err)
+	suite.Assert().Equal(expected, result)
+}
+
+func (suite *StorageSuite) TestFetch_Should_fetch_only_first_unprocessed_row_of_ordering_key() {
+	initOrderedRows(suite.db, "")
+
+	ctx := context.Background()
+
+	result, err := suite.storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]string{outbox.ID1(), outbox.ID3()}, recordIDs(result))
+
+	record := outbox.Record1()
+	record.Done()
+
+	err = suite.storage.Update(ctx, []*outbox.Record{record})
+	suite.Require().NoError(err)
+
+	result, err = suite.storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]string{outbox.ID2()}, recordIDs(result))
+}
+
+func (suite *StorageSuite) TestFetch_Should_not_fetch_rows_after_failed_row_with_same_ordering_key() {
+	initOrderedRows(suite.db, "failed")
+
+	ctx := context.Background()
+
+	result, err := suite.storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]string{outbox.ID3()}, recordIDs(result))
+}
+
+func (suite *StorageSuite) TestFetch_Should_fetch_delayed_rows_only_after_publish_time() {
+	ctx := context.Background()
+
+	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
+
+	record := outbox.NewRecord(outbox.ID1(), "topic1", &payload, outbox.WithPublishAt(time.Now().Add(time.Hour)))
+
+	err := suite.storage.Insert(ctx, suite.db, record)
+	suite.Require().NoError(err)
+
+	_, err = suite.storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().ErrorIs(err, outbox.ErrNoRecrods)
+
+	result, err := suite.storage.Fetch(ctx, time.Now().UTC().Add(2*time.Hour))
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]string{outbox.ID1()}, recordIDs(result))
+}
+
 func (suite *StorageSuite) TestUpdate_Should_update_provided_records_with_new_status() {
 	initInProgressRows(suite.db)
 
 	ctx := context.Background()
 
 	record := outbox.Record1()
-	record.Fail()
+	record.Fail(errors.New("err"))
 
 	err := suite.storage.Update(ctx, []*outbox.Record{record})
 	suite.Require().NoError(err)
@@ -116,6 +223,48 @@ func (suite *StorageSuite) TestUpdate_Should_update_provided_records_with_new_st
 	}
 }
 
+func (suite *StorageSuite) TestUpdateAttempts_Should_save_failed_attempt_of_records() {
+	initInProgressRows(suite.db)
+
+	ctx := context.Background()
+
+	record := outbox.Record1()
+	record.Fail(errors.New("err"))
+	record.CalcNewDeadline(time.Minute)
+
+	err := suite.storage.UpdateAttempts(ctx, []*outbox.Record{record})
+	suite.Require().NoError(err)
+
+	{
+		var (
+			sqlStr          = "select status, attempt, error_message, next_attempt from __outbox_table where id = $1;"
+			destStatus      string
+			destAttempt     int
+			destMessage     string
+			destNextAttempt time.Time
+		)
+		_ = suite.db.QueryRow(sqlStr, outbox.ID1()).Scan(&destStatus, &destAttempt, &destMessage, &destNextAttempt)
+		suite.Assert().Equal("failed", destStatus)
+		suite.Assert().Equal(1, destAttempt)
+		suite.Assert().Equal("err", destMessage)
+		suite.Assert().Greater(destNextAttempt, time.Now().UTC())
+	}
+}
+
+func (suite *StorageSuite) TestFetch_Should_fetch_failed_rows_only_after_next_attempt_time() {
+	initFailedRows(suite.db)
+
+	ctx := context.Background()
+
+	_, err := suite.storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().ErrorIs(err, outbox.ErrNoRecrods)
+
+	result, err := suite.storage.Fetch(ctx, time.Now().UTC().Add(2*time.Minute))
+	suite.Require().NoError(err)
+	suite.Require().Len(result, 1)
+	suite.Assert().Equal(2, result[0].Attempt())
+}
+
 func (suite *StorageSuite) TestUpdate_Should_set_null_status_to_record() {
 	initInProgressRows(suite.db)
 
@@ -148,45 +297,129 @@ func (suite *StorageSuite) TestInsert_Should_insert_new_records_to_table() {
 
 	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
 
-	newRecord := outbox.NewRecord(outbox.ID3(), "topic2", &payload)
+	newRecord := outbox.NewRecord(outbox.ID3(), "topic2", &payload, outbox.WithHeaders(map[string]string{"a": "b"}))
 
 	err := suite.storage.Insert(ctx, suite.db, newRecord)
 	suite.Assert().NoError(err)
 
 	{
 		var (
-			sqlStr      = "select status, event_type, payload from __outbox_table where id = $1;"
+			sqlStr      = "select status, event_type, payload, headers from __outbox_table where id = $1;"
 			status      = sql.NullString{}
 			eventType   = "topic2"
 			payload     = []byte("{}")
+			headers     = `{"a": "b"}`
 			destStatus  sql.NullString
 			destType    string
 			destPayload []byte
+			destHeaders string
 		)
-		_ = suite.db.QueryRow(sqlStr, outbox.ID3()).Scan(&destStatus, &destType, &destPayload)
+		_ = suite.db.QueryRow(sqlStr, outbox.ID3()).Scan(&destStatus, &destType, &destPayload, &destHeaders)
 		suite.Assert().Equal(status, destStatus)
 		suite.Assert().Equal(eventType, destType)
 		suite.Assert().Equal(payload, destPayload)
+		suite.Assert().Equal(headers, destHeaders)
+	}
+}
+
+func (suite *StorageSuite) TestInsertBatch_Should_insert_records_and_return_duplicates() {
+	ctx := context.Background()
+
+	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
+
+	err := suite.storage.Insert(ctx, suite.db, outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+	suite.Require().NoError(err)
+
+	records := []*outbox.Record{
+		outbox.NewRecord(outbox.ID1(), "topic1", &payload),
+		outbox.NewRecord(outbox.ID2(), "topic1", &payload),
+		outbox.NewRecord(outbox.ID2(), "topic1", &payload),
+		outbox.NewRecord(outbox.ID3(), "topic1", &payload, outbox.WithOrderingKey("order-1")),
+	}
+
+	duplicates, err := suite.storage.InsertBatch(ctx, suite.db, records)
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]string{outbox.ID1(), outbox.ID2()}, duplicates)
+
+	{
+		var (
+			sqlStr    = "select count(*) from __outbox_table where id in ($1, $2, $3);"
+			expected  = 3
+			destCount int
+		)
+		_ = suite.db.QueryRow(sqlStr, outbox.ID1(), outbox.ID2(), outbox.ID3()).Scan(&destCount)
+		suite.Assert().Equal(expected, destCount)
 	}
 }
 
+func (suite *StorageSuite) TestInitOutboxTable_Should_create_table_with_custom_name_and_schema() {
+	ctx := context.Background()
+
+	storage := outbox.NewStorage(suite.db, outbox.WithSchema("custom"), outbox.WithTableName("events"))
+
+	err := storage.InitOutboxTable(ctx)
+	suite.Require().NoError(err)
+
+	defer func() {
+		_, _ = suite.db.Exec("drop schema custom cascade;")
+	}()
+
+	payload := outbox.PayloadMarshaler{Body: []byte("{}")}
+
+	err = storage.Insert(ctx, suite.db, outbox.NewRecord(outbox.ID1(), "topic1", &payload))
+	suite.Require().NoError(err)
+
+	result, err := storage.Fetch(ctx, time.Now().UTC())
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]string{outbox.ID1()}, recordIDs(result))
+
+	{
+		var (
+			sqlStr    = "select count(*) from __outbox_table;"
+			expected  = 0
+			destCount int
+		)
+		_ = suite.db.QueryRow(sqlStr).Scan(&destCount)
+		suite.Assert().Equal(expected, destCount)
+	}
+}
+
+func (suite *StorageSuite) TestStorage_Should_pass_conformance_tests() {
+	storagetest.Run(suite.T(), func(*testing.T) (outbox.Storage, outbox.Execer) {
+		_, err := suite.db.Exec("delete from __outbox_table;")
+		suite.Require().NoError(err)
+
+		return suite.storage, suite.db
+	})
+}
+
 func TestStorageSuite(t *testing.T) {
 	suite.Run(t, &StorageSuite{})
 }
 
+func TestInsertPlaceholders(t *testing.T) {
+	t.Run("should return placeholders of the first row", func(t *testing.T) {
+		assert.Equal(t, "($1, $2, $3, $4, $5, $6)", outbox.InsertPlaceholders(0))
+	})
+
+	t.Run("should return placeholders of the row after offset", func(t *testing.T) {
+		assert.Equal(t, "($7, $8, $9, $10, $11, $12)", outbox.InsertPlaceholders(6))
+	})
+}
+
 func truncateTable(db *sql.DB) {
 	_, _ = db.Exec("delete from __outbox_table where id in ($1, $2, $3)", outbox.ID1(), outbox.ID2(), outbox.ID3())
 }
 
 func initNotProcessedRows(db *sql.DB) {
 	_, _ = db.Exec(
-		"insert into __outbox_table (id, event_type, payload) values ($1, $2, $3)",
-		outbox.ID1(), "topic1", "{}",
+		"insert into __outbox_table (id, event_type, payload, created_at) values ($1, $2, $3, $4)",
+		outbox.ID1(), "topic1", "{}", "2000-01-01 01:13:00.000000",
 	)
 
 	_, _ = db.Exec(
-		"insert into __outbox_table (id, event_type, payload) values ($1, $2, $3)",
-		outbox.ID2(), "topic1", "{}",
+		"insert into __outbox_table (id, event_type, payload, created_at) values ($1, $2, $3, $4)",
+		outbox.ID2(), "topic1", "{}", "2000-01-01 01:15:00.000000",
 	)
 
 	_, _ = db.Exec(
@@ -197,16 +430,56 @@ func initNotProcessedRows(db *sql.DB) {
 
 func initInProgressRows(db *sql.DB) {
 	_, _ = db.Exec(
-		"insert into __outbox_table (id, status, event_type, payload) values ($1, $2, $3, $4)",
-		outbox.ID1(), "progress", "topic1", "{}",
+		"insert into __outbox_table (id, status, event_type, payload, created_at) values ($1, $2, $3, $4, $5)",
+		outbox.ID1(), "progress", "topic1", "{}", "2000-01-01 01:13:00.000000",
 	)
 
 	_, _ = db.Exec(
-		"insert into __outbox_table (id, status, event_type, payload) values ($1, $2, $3, $4)",
-		outbox.ID2(), "progress", "topic1", "{}",
+		"insert into __outbox_table (id, status, event_type, payload, created_at) values ($1, $2, $3, $4, $5)",
+		outbox.ID2(), "progress", "topic1", "{}", "2000-01-01 01:15:00.000000",
 	)
 }
 
+func initFailedRows(db *sql.DB) {
+	_, _ = db.Exec(
+		"insert into __outbox_table (id, status, event_type, payload, attempt, next_attempt) "+
+			"values ($1, $2, $3, $4, $5, (now() at time zone 'utc') + interval '1 minute')",
+		outbox.ID1(), "failed", "topic1", "{}", 2,
+	)
+}
+
+// initOrderedRows inserts two rows with the same ordering key and
+// one row with another key. The first row gets the provided status.
+func initOrderedRows(db *sql.DB, firstStatus string) {
+	status := sql.NullString{String: firstStatus, Valid: firstStatus != ""}
+
+	_, _ = db.Exec(
+		"insert into __outbox_table (id, status, event_type, payload, ordering_key, next_attempt, created_at) "+
+			"values ($1, $2, $3, $4, $5, (now() at time zone 'utc') + interval '1 minute', $6)",
+		outbox.ID1(), status, "topic1", "{}", "order-1", "2000-01-01 01:13:00.000000",
+	)
+
+	_, _ = db.Exec(
+		"insert into __outbox_table (id, event_type, payload, ordering_key, created_at) values ($1, $2, $3, $4, $5)",
+		outbox.ID2(), "topic1", "{}", "order-1", "2000-01-01 01:15:00.000000",
+	)
+
+	_, _ = db.Exec(
+		"insert into __outbox_table (id, event_type, payload, ordering_key, created_at) values ($1, $2, $3, $4, $5)",
+		outbox.ID3(), "topic1", "{}", "order-2", "2000-01-01 01:17:00.000000",
+	)
+}
+
+func recordIDs(records []*outbox.Record) []string {
+	ids := make([]string, 0, len(records))
+
+	for _, record := range records {
+		ids = append(ids, record.Message(nil).ID)
+	}
+
+	return ids
+}
+
 func initDoneRows(db *sql.DB) {
 	_, _ = db.Exec(
 		"insert into __outbox_table (id, status, event_type, payload) values ($1, $2, $3, $4)",
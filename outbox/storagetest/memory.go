@@ -0,0 +1,242 @@
+package storagetest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Melenium2/go-iobox/outbox"
+)
+
+var _ outbox.Storage = (*MemoryStorage)(nil)
+
+type memoryRecord struct {
+	state     outbox.RecordState
+	seq       int
+	updatedAt time.Time
+}
+
+// MemoryStorage is outbox.Storage which keeps records in memory. It is
+// useful as a test double of the outbox storage. The tx argument of
+// insert methods is ignored.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	seq     int
+	records []*memoryRecord
+
+	batchSize    int
+	leaseTimeout time.Duration
+}
+
+// NewMemoryStorage creates new empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		batchSize:    outbox.DefaultBatchSize,
+		leaseTimeout: outbox.DefaultLeaseTimeout,
+	}
+}
+
+func (s *MemoryStorage) InitOutboxTable(context.Context) error {
+	return nil
+}
+
+func (s *MemoryStorage) Fetch(_ context.Context, fetchTime time.Time) ([]*outbox.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		leaseDeadline = fetchTime.Add(-s.leaseTimeout)
+		claimed       = make([]*memoryRecord, 0)
+	)
+
+	for _, curr := range s.records {
+		if !s.ready(curr, fetchTime, leaseDeadline) || s.blocked(curr) {
+			continue
+		}
+
+		claimed = append(claimed, curr)
+	}
+
+	sort.SliceStable(claimed, func(i, j int) bool {
+		if claimed[i].state.CreatedAt.Equal(claimed[j].state.CreatedAt) {
+			return claimed[i].seq < claimed[j].seq
+		}
+
+		return claimed[i].state.CreatedAt.Before(claimed[j].state.CreatedAt)
+	})
+
+	if len(claimed) > s.batchSize {
+		claimed = claimed[:s.batchSize]
+	}
+
+	if len(claimed) == 0 {
+		return nil, outbox.ErrNoRecrods
+	}
+
+	now := time.Now().UTC()
+	result := make([]*outbox.Record, 0, len(claimed))
+
+	for _, curr := range claimed {
+		curr.state.Status = outbox.Progress
+		curr.updatedAt = now
+
+		result = append(result, outbox.RestoreRecord(curr.state))
+	}
+
+	return result, nil
+}
+
+// ready reports whether the record can be published at fetchTime.
+func (s *MemoryStorage) ready(curr *memoryRecord, fetchTime, leaseDeadline time.Time) bool {
+	switch curr.state.Status {
+	case outbox.Null:
+		return curr.state.PublishAt.IsZero() || !curr.state.PublishAt.After(fetchTime)
+	case outbox.Failed:
+		return !curr.state.NextAttempt.After(fetchTime)
+	case outbox.Progress:
+		return !curr.updatedAt.After(leaseDeadline)
+	default:
+		return false
+	}
+}
+
+// blocked reports whether the previous record with the same ordering
+// key is not published yet.
+func (s *MemoryStorage) blocked(curr *memoryRecord) bool {
+	if curr.state.OrderingKey == "" {
+		return false
+	}
+
+	for _, prev := range s.records {
+		if prev.seq >= curr.seq || prev.state.OrderingKey != curr.state.OrderingKey {
+			continue
+		}
+
+		if prev.state.Status != outbox.Done && prev.state.Status != outbox.Dead {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *MemoryStorage) Update(_ context.Context, records []*outbox.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for _, record := range records {
+		if curr := s.find(record.ID()); curr != nil {
+			curr.state.Status = record.Status()
+			curr.updatedAt = now
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) UpdateAttempts(_ context.Context, records []*outbox.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for _, record := range records {
+		if curr := s.find(record.ID()); curr != nil {
+			curr.state.Status = record.Status()
+			curr.state.Attempt = record.Attempt()
+			curr.state.ErrorMessage = record.ErrorMessage()
+			curr.state.NextAttempt = record.NextAttempt()
+			curr.updatedAt = now
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) Insert(ctx context.Context, tx outbox.Execer, record *outbox.Record) error {
+	_, err := s.InsertBatch(ctx, tx, []*outbox.Record{record})
+
+	return err
+}
+
+func (s *MemoryStorage) InsertBatch(_ context.Context, _ outbox.Execer, records []*outbox.Record) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	duplicates := make([]string, 0)
+
+	for _, record := range records {
+		if s.find(record.ID()) != nil {
+			duplicates = append(duplicates, record.ID())
+
+			continue
+		}
+
+		payload, err := record.Payload().MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		s.seq++
+
+		s.records = append(s.records, &memoryRecord{
+			state: outbox.RecordState{
+				ID:          record.ID(),
+				EventType:   record.EventType(),
+				Payload:     payload,
+				Headers:     record.Headers(),
+				OrderingKey: record.OrderingKey(),
+				PublishAt:   record.PublishAt(),
+				CreatedAt:   time.Now().UTC(),
+			},
+			seq: s.seq,
+		})
+	}
+
+	return duplicates, nil
+}
+
+func (s *MemoryStorage) Purge(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		kept    = make([]*memoryRecord, 0, len(s.records))
+		deleted int64
+		day     = truncateDay(before)
+	)
+
+	for _, curr := range s.records {
+		if truncateDay(curr.state.CreatedAt).Before(day) {
+			deleted++
+
+			continue
+		}
+
+		kept = append(kept, curr)
+	}
+
+	s.records = kept
+
+	return deleted, nil
+}
+
+func (s *MemoryStorage) find(id string) *memoryRecord {
+	for _, curr := range s.records {
+		if curr.state.ID == id {
+			return curr
+		}
+	}
+
+	return nil
+}
+
+// truncateDay returns the date of t, the same as Postgres `::date` does.
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,350 @@
+// Package storagetest provides the conformance test suite of
+// outbox.Storage implementations and the in-memory storage.
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) (outbox.Storage, outbox.Execer) {
+//			return newEmptyStorage(t), db
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Melenium2/go-iobox/outbox"
+)
+
+// Factory returns empty initialized storage and Execer used to insert
+// records. It is called for each test.
+type Factory func(t *testing.T) (outbox.Storage, outbox.Execer)
+
+type payload []byte
+
+func (p payload) MarshalJSON() ([]byte, error) {
+	return p, nil
+}
+
+// body is the payload of records in the canonical json format, so
+// storages which normalize json return it unchanged.
+var body = payload(`{"a": 1}`)
+
+// Run runs the conformance tests against the storage. The storage must
+// use the default batch size and lease timeout.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage outbox.Storage, tx outbox.Execer)
+	}{
+		{"Fetch_Should_return_error_if_storage_is_empty", testFetchEmpty},
+		{"Fetch_Should_claim_inserted_records", testFetchInserted},
+		{"Fetch_Should_not_return_claimed_records", testFetchClaimed},
+		{"Fetch_Should_return_claimed_records_after_lease", testFetchLease},
+		{"Fetch_Should_return_delayed_records_after_publish_time", testFetchDelayed},
+		{"Fetch_Should_return_only_first_record_of_ordering_key", testFetchOrderingKey},
+		{"Fetch_Should_not_block_ordering_key_by_dead_record", testFetchOrderingKeyDead},
+		{"Insert_Should_ignore_duplicates", testInsertDuplicate},
+		{"InsertBatch_Should_return_duplicates", testInsertBatchDuplicates},
+		{"Update_Should_not_return_done_and_dead_records", testUpdateDone},
+		{"Update_Should_return_released_records_again", testUpdateNull},
+		{"UpdateAttempts_Should_return_failed_records_after_next_attempt", testUpdateAttempts},
+		{"Purge_Should_delete_records_created_before_date", testPurge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, tx := factory(t)
+
+			tt.test(t, storage, tx)
+		})
+	}
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+func insert(t *testing.T, storage outbox.Storage, tx outbox.Execer, records ...*outbox.Record) {
+	t.Helper()
+
+	for _, record := range records {
+		err := storage.Insert(context.Background(), tx, record)
+		require.NoError(t, err)
+	}
+}
+
+func fetchIDs(t *testing.T, storage outbox.Storage, fetchTime time.Time) []string {
+	t.Helper()
+
+	records, err := storage.Fetch(context.Background(), fetchTime)
+	if errors.Is(err, outbox.ErrNoRecrods) {
+		return nil
+	}
+
+	require.NoError(t, err)
+
+	ids := make([]string, 0, len(records))
+
+	for _, record := range records {
+		ids = append(ids, record.ID())
+	}
+
+	return ids
+}
+
+func fetch(t *testing.T, storage outbox.Storage) []*outbox.Record {
+	t.Helper()
+
+	records, err := storage.Fetch(context.Background(), now())
+	require.NoError(t, err)
+
+	return records
+}
+
+func testFetchEmpty(t *testing.T, storage outbox.Storage, _ outbox.Execer) {
+	_, err := storage.Fetch(context.Background(), now())
+	assert.ErrorIs(t, err, outbox.ErrNoRecrods)
+}
+
+func testFetchInserted(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	var (
+		id1 = uuid.NewString()
+		id2 = uuid.NewString()
+	)
+
+	insert(t, storage, tx,
+		outbox.NewRecord(id1, "topic1", body, outbox.WithHeaders(map[string]string{"trace-id": "1"})),
+		outbox.NewRecord(id2, "topic2", body, outbox.WithOrderingKey("order-1")),
+	)
+
+	records := fetch(t, storage)
+	require.Len(t, records, 2)
+
+	first, second := records[0], records[1]
+
+	assert.Equal(t, id1, first.ID())
+	assert.Equal(t, "topic1", first.EventType())
+	assert.Equal(t, outbox.Progress, first.Status())
+	assert.Equal(t, map[string]string{"trace-id": "1"}, first.Headers())
+	assert.Equal(t, 0, first.Attempt())
+	assert.False(t, first.CreatedAt().IsZero())
+
+	firstPayload, err := first.Payload().MarshalJSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, string(body), string(firstPayload))
+
+	assert.Equal(t, id2, second.ID())
+	assert.Equal(t, "topic2", second.EventType())
+	assert.Equal(t, "order-1", second.OrderingKey())
+	assert.Empty(t, second.Headers())
+	assert.False(t, second.CreatedAt().Before(first.CreatedAt()))
+}
+
+func testFetchClaimed(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	id := uuid.NewString()
+
+	insert(t, storage, tx, outbox.NewRecord(id, "topic1", body))
+
+	assert.Equal(t, []string{id}, fetchIDs(t, storage, now()))
+	assert.Empty(t, fetchIDs(t, storage, now()))
+}
+
+func testFetchLease(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	id := uuid.NewString()
+
+	insert(t, storage, tx, outbox.NewRecord(id, "topic1", body))
+
+	assert.Equal(t, []string{id}, fetchIDs(t, storage, now()))
+
+	// The worker died before the status is updated.
+	leaseExpired := now().Add(outbox.DefaultLeaseTimeout + time.Minute)
+
+	assert.Equal(t, []string{id}, fetchIDs(t, storage, leaseExpired))
+}
+
+func testFetchDelayed(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	var (
+		id        = uuid.NewString()
+		publishAt = now().Add(time.Hour)
+	)
+
+	insert(t, storage, tx, outbox.NewRecord(id, "topic1", body, outbox.WithPublishAt(publishAt)))
+
+	assert.Empty(t, fetchIDs(t, storage, now()))
+	assert.Equal(t, []string{id}, fetchIDs(t, storage, publishAt.Add(time.Second)))
+}
+
+func testFetchOrderingKey(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	var (
+		id1 = uuid.NewString()
+		id2 = uuid.NewString()
+		id3 = uuid.NewString()
+	)
+
+	insert(t, storage, tx,
+		outbox.NewRecord(id1, "topic1", body, outbox.WithOrderingKey("order-1")),
+		outbox.NewRecord(id2, "topic1", body, outbox.WithOrderingKey("order-1")),
+		outbox.NewRecord(id3, "topic1", body, outbox.WithOrderingKey("order-2")),
+	)
+
+	records := fetch(t, storage)
+	assert.Equal(t, []string{id1, id3}, ids(records))
+
+	for _, record := range records {
+		record.Done()
+	}
+
+	err := storage.Update(context.Background(), records)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{id2}, fetchIDs(t, storage, now()))
+}
+
+func testFetchOrderingKeyDead(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	var (
+		id1 = uuid.NewString()
+		id2 = uuid.NewString()
+	)
+
+	insert(t, storage, tx,
+		outbox.NewRecord(id1, "topic1", body, outbox.WithOrderingKey("order-1")),
+		outbox.NewRecord(id2, "topic1", body, outbox.WithOrderingKey("order-1")),
+	)
+
+	records := fetch(t, storage)
+	require.Equal(t, []string{id1}, ids(records))
+
+	records[0].Fail(errors.New("broker is down"))
+	records[0].Dead()
+
+	err := storage.UpdateAttempts(context.Background(), records)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{id2}, fetchIDs(t, storage, now()))
+}
+
+func testInsertDuplicate(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	id := uuid.NewString()
+
+	insert(t, storage, tx,
+		outbox.NewRecord(id, "topic1", body),
+		outbox.NewRecord(id, "topic2", body),
+	)
+
+	records := fetch(t, storage)
+	require.Len(t, records, 1)
+	assert.Equal(t, "topic1", records[0].EventType())
+}
+
+func testInsertBatchDuplicates(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	var (
+		id1 = uuid.NewString()
+		id2 = uuid.NewString()
+	)
+
+	insert(t, storage, tx, outbox.NewRecord(id1, "topic1", body))
+
+	records := []*outbox.Record{
+		outbox.NewRecord(id1, "topic1", body),
+		outbox.NewRecord(id2, "topic1", body),
+		outbox.NewRecord(id2, "topic1", body),
+	}
+
+	duplicates, err := storage.InsertBatch(context.Background(), tx, records)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id1, id2}, duplicates)
+	assert.Equal(t, []string{id1, id2}, fetchIDs(t, storage, now()))
+}
+
+func testUpdateDone(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	var (
+		id1 = uuid.NewString()
+		id2 = uuid.NewString()
+	)
+
+	insert(t, storage, tx, outbox.NewRecord(id1, "topic1", body), outbox.NewRecord(id2, "topic1", body))
+
+	records := fetch(t, storage)
+	require.Len(t, records, 2)
+
+	records[0].Done()
+	records[1].Dead()
+
+	err := storage.Update(context.Background(), records[:1])
+	require.NoError(t, err)
+
+	err = storage.UpdateAttempts(context.Background(), records[1:])
+	require.NoError(t, err)
+
+	leaseExpired := now().Add(outbox.DefaultLeaseTimeout + time.Minute)
+
+	assert.Empty(t, fetchIDs(t, storage, leaseExpired))
+}
+
+func testUpdateNull(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	id := uuid.NewString()
+
+	insert(t, storage, tx, outbox.NewRecord(id, "topic1", body))
+
+	records := fetch(t, storage)
+	records[0].Null()
+
+	err := storage.Update(context.Background(), records)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{id}, fetchIDs(t, storage, now()))
+}
+
+func testUpdateAttempts(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	id := uuid.NewString()
+
+	insert(t, storage, tx, outbox.NewRecord(id, "topic1", body))
+
+	records := fetch(t, storage)
+	records[0].Fail(errors.New("broker is down"))
+	records[0].CalcNewDeadline(time.Hour)
+
+	err := storage.UpdateAttempts(context.Background(), records)
+	require.NoError(t, err)
+
+	assert.Empty(t, fetchIDs(t, storage, now()))
+
+	records, err = storage.Fetch(context.Background(), now().Add(time.Hour+time.Minute))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, id, records[0].ID())
+	assert.Equal(t, 1, records[0].Attempt())
+}
+
+func testPurge(t *testing.T, storage outbox.Storage, tx outbox.Execer) {
+	ctx := context.Background()
+
+	insert(t, storage, tx, outbox.NewRecord(uuid.NewString(), "topic1", body))
+
+	deleted, err := storage.Purge(ctx, now().AddDate(0, 0, -1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	deleted, err = storage.Purge(ctx, now().AddDate(0, 0, 2))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = storage.Fetch(ctx, now())
+	assert.ErrorIs(t, err, outbox.ErrNoRecrods)
+}
+
+func ids(records []*outbox.Record) []string {
+	result := make([]string, 0, len(records))
+
+	for _, record := range records {
+		result = append(result, record.ID())
+	}
+
+	return result
+}
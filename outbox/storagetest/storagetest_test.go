@@ -0,0 +1,14 @@
+package storagetest_test
+
+import (
+	"testing"
+
+	"github.com/Melenium2/go-iobox/outbox"
+	"github.com/Melenium2/go-iobox/outbox/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(*testing.T) (outbox.Storage, outbox.Execer) {
+		return storagetest.NewMemoryStorage(), nil
+	})
+}
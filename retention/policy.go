@@ -4,7 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sync"
 	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
 var (
@@ -43,13 +47,37 @@ func defaultConfig() Config {
 	}
 }
 
+// Purger deletes data created before the specified date.
+type Purger interface {
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// tablePurger deletes rows of the table by `created_at` field.
+type tablePurger struct {
+	conn      *sql.DB
+	tableName string
+}
+
+func (p *tablePurger) Purge(ctx context.Context, before time.Time) (int64, error) {
+	sqlstr := "delete from " + p.tableName + " where created_at::date < $1::date;"
+
+	result, err := p.conn.ExecContext(ctx, sqlstr, before)
+	if err != nil {
+		return 0, fmt.Errorf("erase sql not executed, %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
 // Policy is a structure that deletes data older than specified interval.
 // This is useful if we do not need to keep all old data all the time.
 type Policy struct {
-	tableName string
-	config    Config
+	purger Purger
+	config Config
 
-	conn *sql.DB
+	once sync.Once
+	// Channel to stop Policy.
+	stop chan struct{}
 }
 
 // NewPolicy creates new Policy to erasing old data at the specified table.
@@ -62,6 +90,13 @@ type Policy struct {
 //	tableName - the name of the table in which we need to save the data window only.
 //	config - optional configuration of retention policy.
 func NewPolicy(conn *sql.DB, tableName string, config ...Config) *Policy {
+	return NewPurgerPolicy(&tablePurger{conn: conn, tableName: tableName}, config...)
+}
+
+// NewPurgerPolicy creates new Policy which erases old data with the purger.
+// Use it if the data is not stored in the Postgres table, e.g. storages of
+// outbox/mysqlstorage and inbox/mysqlstorage implement Purger.
+func NewPurgerPolicy(purger Purger, config ...Config) *Policy {
 	cfg := defaultConfig()
 
 	if len(config) > 0 && config[0].EraseInterval > 0 {
@@ -77,55 +112,58 @@ func NewPolicy(conn *sql.DB, tableName string, config ...Config) *Policy {
 	}
 
 	return &Policy{
-		conn:      conn,
-		tableName: tableName,
-		config:    cfg,
+		purger: purger,
+		config: cfg,
+		stop:   make(chan struct{}),
 	}
 }
 
+// NewPolicyFromPool creates new Policy which uses connections of the pgx pool.
+func NewPolicyFromPool(pool *pgxpool.Pool, tableName string, config ...Config) *Policy {
+	return NewPolicy(stdlib.OpenDBFromPool(pool), tableName, config...)
+}
+
 // Start retention process. The function is blocking the main loop.
-// Close the context to stop erasing process.
+// Close the context or call Stop to stop erasing process. Closing the
+// context also cancels erasing that is already started.
 func (p *Policy) Start(ctx context.Context) {
 	ticker := time.NewTicker(p.config.EraseInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case now := <-ticker.C:
 			tailDate := p.tailDate(now, p.config.RetentionWindowDays)
 
-			if err := p.iteration(tailDate); err != nil {
+			if err := p.iteration(ctx, tailDate); err != nil {
 				p.config.ErrorCallback(err)
 			}
 		case <-ctx.Done():
 			return
+		case <-p.stop:
+			return
 		}
 	}
 }
 
+// Stop stops retention process. Erasing that is already
+// started is not interrupted.
+func (p *Policy) Stop() {
+	p.once.Do(p.stopPolicy)
+}
+
+func (p *Policy) stopPolicy() { close(p.stop) }
+
 func (p *Policy) tailDate(now time.Time, window int) time.Time {
 	return now.AddDate(0, 0, -window)
 }
 
-func (p *Policy) iteration(tailDate time.Time) error {
-	ctx := context.Background()
-
+func (p *Policy) iteration(ctx context.Context, tailDate time.Time) error {
 	_, err := p.erase(ctx, tailDate)
 
 	return err
 }
 
 func (p *Policy) erase(ctx context.Context, tailDate time.Time) (int64, error) {
-	sqlstr := "delete from " + p.tableName + " where created_at::date < $1::date;"
-
-	result, err := p.conn.ExecContext(ctx, sqlstr, tailDate)
-	if err != nil {
-		return 0, fmt.Errorf("erase sql not executed, %w", err)
-	}
-
-	affected, err := result.RowsAffected()
-	if err != nil {
-		return 0, err
-	}
-
-	return affected, nil
+	return p.purger.Purge(ctx, tailDate)
 }
@@ -174,3 +174,25 @@ func TestTailDate(t *testing.T) {
 		assert.Equal(t, expected, res)
 	})
 }
+
+func TestStop(t *testing.T) {
+	t.Run("should stop retention process", func(t *testing.T) {
+		svc := NewPolicy(nil, tableName)
+
+		done := make(chan struct{})
+
+		go func() {
+			svc.Start(context.Background())
+			close(done)
+		}()
+
+		svc.Stop()
+		svc.Stop()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("retention process is not stopped")
+		}
+	})
+}